@@ -1,15 +1,25 @@
 package serverbox
 
 import (
+	"github.com/BurntSushi/toml"
 	"github.com/ramdrjn/serverbox/pkgs/common"
 	"os"
+	"sync"
 )
 
 type SbContext struct {
 	Log           common.Logger
+	ConfPath      string
 	Conf          serverBoxConf
+	ConfMeta      toml.MetaData
 	Servers       map[string]*Server
 	SignalChannel chan os.Signal
+	Errors        chan error
+
+	//mu guards Servers and Conf against a reload, reload serializes
+	//reloads
+	mu     sync.RWMutex
+	reload sync.Mutex
 }
 
 var Log common.Logger
@@ -21,27 +31,45 @@ type serverBoxConf struct {
 type server struct {
 	Bind_ip        string
 	Bind_port      uint16
+	Bind_unix      string
+	Unix_mode      string
+	Unix_owner     string
+	Start_timeout  uint32
+	Drain_timeout  uint32
 	Debug          bool
 	Type           string
 	Statistics     statistics
 	State          state
-	Configurations ServerConfigurations
+	Configurations toml.Primitive
 }
 
 type statistics struct {
-	Host    string
-	Port    uint16
-	Enabled bool
+	Host         string
+	Port         uint16
+	Enabled      bool
+	Interval     uint32
+	Buffer       uint32
+	Dial_timeout uint32
+	Backoff_max  uint32
+	Lazy         bool
+	Tls          common.TlsConf
 }
 
 type state struct {
-	Host    string
-	Port    uint16
-	Enabled bool
+	Host         string
+	Port         uint16
+	Enabled      bool
+	Ttl          uint32
+	Dial_timeout uint32
+	Backoff_max  uint32
+	Lazy         bool
+	Tls          common.TlsConf
 }
 
 type ServerConfigurations struct {
-	Http httpConfigurations
+	Http   httpConfigurations
+	Grpc   grpcConfigurations
+	Socket socketConfigurations
 }
 
 type httpConfigurations struct {
@@ -50,4 +78,27 @@ type httpConfigurations struct {
 	Static_path  string
 	Strip_path   string
 	Template_dir string
+	Tls          httpsConfigurations
+}
+
+type socketConfigurations struct {
+	Read_timeout    uint32
+	Write_timeout   uint32
+	Max_connections int
+	Packet_size     int
+}
+
+type grpcConfigurations struct {
+	Tls common.TlsConf
+}
+
+type httpsConfigurations struct {
+	Cert            string
+	Key             string
+	Reload_interval uint32
+	Min_version     string
+	Cipher_suites   []string
+	Client_ca       string
+	Client_auth     string
+	Redirect_port   uint16
 }
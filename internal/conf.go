@@ -0,0 +1,21 @@
+package serverbox
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+// RawConf is the configurations table of a server, left for the factory
+// of its server type to decode into its own type.
+type RawConf struct {
+	md      toml.MetaData
+	prim    toml.Primitive
+	defined bool
+}
+
+// Decode leaves v untouched when the server has no configurations.
+func (c RawConf) Decode(v interface{}) error {
+	if !c.defined {
+		return nil
+	}
+	return c.md.PrimitiveDecode(c.prim, v)
+}
@@ -0,0 +1,51 @@
+package serverbox
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// grpcInterceptors record request count, status code, latency and
+// in-flight requests of every call, labelled with the full method name.
+func grpcInterceptors(stats *Statistics) []grpc.ServerOption {
+	if !stats.enabled {
+		return nil
+	}
+
+	record := func(method string, start time.Time, err error) {
+		stats.Counter("grpc_requests_total", map[string]string{
+			"method": method,
+			"code":   status.Code(err).String(),
+		}, 1)
+		stats.Observe("grpc_request_duration_seconds",
+			map[string]string{"method": method}, nil,
+			time.Since(start).Seconds())
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		labels := map[string]string{"method": info.FullMethod}
+		stats.AddGauge("grpc_requests_in_flight", labels, 1)
+		defer stats.AddGauge("grpc_requests_in_flight", labels, -1)
+
+		start := time.Now()
+		res, err := handler(ctx, req)
+		record(info.FullMethod, start, err)
+		return res, err
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		labels := map[string]string{"method": info.FullMethod}
+		stats.AddGauge("grpc_requests_in_flight", labels, 1)
+		defer stats.AddGauge("grpc_requests_in_flight", labels, -1)
+
+		start := time.Now()
+		err := handler(srv, ss)
+		record(info.FullMethod, start, err)
+		return err
+	}
+
+	return []grpc.ServerOption{grpc.UnaryInterceptor(unary),
+		grpc.StreamInterceptor(stream)}
+}
@@ -0,0 +1,110 @@
+package serverbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+type countingBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websockets and other upgrades through, what is written to a
+// hijacked connection is not counted.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// instrument records request count, status class, latency, in-flight
+// requests and bytes in and out of every request to h, labelled with the
+// route pattern h was registered for.
+func instrument(stats *Statistics, route string, h http.Handler) http.Handler {
+	if !stats.enabled {
+		return h
+	}
+	routeLabels := map[string]string{"route": route}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		stats.AddGauge("http_requests_in_flight", routeLabels, 1)
+		defer stats.AddGauge("http_requests_in_flight", routeLabels, -1)
+
+		body := &countingBody{ReadCloser: req.Body}
+		if req.Body != nil {
+			req.Body = body
+		}
+		w := &statusWriter{ResponseWriter: res}
+		start := time.Now()
+
+		h.ServeHTTP(w, req)
+
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		stats.Counter("http_requests_total", map[string]string{
+			"route":  route,
+			"method": req.Method,
+			"code":   fmt.Sprintf("%dxx", w.status/100),
+		}, 1)
+		stats.Observe("http_request_duration_seconds", routeLabels, nil,
+			time.Since(start).Seconds())
+		stats.Counter("http_request_bytes_total", routeLabels,
+			float64(body.bytes))
+		stats.Counter("http_response_bytes_total", routeLabels,
+			float64(w.bytes))
+	})
+}
@@ -0,0 +1,268 @@
+package serverbox
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Status is where a server is in its lifecycle.
+type Status string
+
+const (
+	StatusStopped  Status = "stopped"
+	StatusStarting Status = "starting"
+	StatusRunning  Status = "running"
+	StatusStopping Status = "stopping"
+	StatusFailed   Status = "failed"
+)
+
+var errNotRunning = errors.New("server is not running")
+
+func (sbc *SbContext) server(serName string) *Server {
+	sbc.mu.RLock()
+	defer sbc.mu.RUnlock()
+
+	return sbc.Servers[serName]
+}
+
+func lookupServer(serName string, sbc *SbContext) (*Server, error) {
+	server := sbc.server(serName)
+	if server == nil {
+		return nil, errors.New("no server named " + serName)
+	}
+	return server, nil
+}
+
+// attach hands the instance to attach and keeps attach to be replayed on
+// the instance a restart creates.
+func (s *Server) attach(attach func(ServerInstance) error) error {
+	s.control.Lock()
+	defer s.control.Unlock()
+
+	err := attach(s.serverInstance)
+	if err == nil {
+		s.attachments = append(s.attachments, attach)
+	}
+	return err
+}
+
+func (s *Server) replay(instance ServerInstance) error {
+	for _, attach := range s.attachments {
+		err := attach(instance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renew replaces an instance that has run, as servers cannot serve again
+// once shut down.
+func (s *Server) renew() error {
+	instance, err := getServerInstance(s, s.conf)
+	if err != nil {
+		return err
+	}
+	err = instance.InitializeServerInstance()
+	if err != nil {
+		return err
+	}
+	err = s.replay(instance)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.serverInstance = instance
+	s.ran = false
+	return nil
+}
+
+func (s *Server) setStatus(status Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+// start runs the instance and waits for it to bind, the caller holds
+// control.
+func (s *Server) start(sbc *SbContext) error {
+	s.mu.Lock()
+	status, ran := s.status, s.ran
+	s.mu.Unlock()
+	if status == StatusRunning {
+		return errors.New("server " + s.name + " is already running")
+	}
+	if ran {
+		err := s.renew()
+		if err != nil {
+			s.setStatus(StatusFailed)
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.status = StatusStarting
+	s.ran = true
+	s.run++
+	run := s.run
+	s.bound = make(chan struct{})
+	bound := s.bound
+	instance := s.serverInstance
+	s.mu.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := instance.RunServerInstance()
+		exited <- err
+		s.exited(sbc, run, err)
+	}()
+
+	timer := time.NewTimer(s.startTimeout)
+	defer timer.Stop()
+	select {
+	case <-bound:
+		return nil
+	case err := <-exited:
+		return err
+	case <-timer.C:
+		//abort rather than leave it to bind later on, unaccounted for
+		s.setStatus(StatusFailed)
+		instance.AbortServerInstance()
+		return fmt.Errorf("not bound within %s", s.startTimeout)
+	}
+}
+
+// exited records how run of the instance ended. A running server that
+// stops on its own is reported on sbc.Errors, the error is only logged
+// once nobody reads the channel and its buffer is full.
+func (s *Server) exited(sbc *SbContext, run int, err error) {
+	s.mu.Lock()
+	if run != s.run {
+		s.mu.Unlock()
+		return
+	}
+	failed := false
+	switch s.status {
+	case StatusStarting:
+		s.status = StatusStopped
+		if err != nil {
+			s.status = StatusFailed
+		}
+	case StatusRunning:
+		s.status = StatusStopped
+		if err != nil {
+			s.status = StatusFailed
+			failed = true
+		}
+	}
+	s.mu.Unlock()
+
+	if failed {
+		Log.Errorf("server %s stopped: %s", s.name, err)
+		select {
+		case sbc.Errors <- &ServerError{s.name, err}:
+		default:
+			Log.Errorf("error of server %s dropped, nobody reads them",
+				s.name)
+		}
+	}
+}
+
+// stop shuts the instance down, draining when graceful, the caller holds
+// control.
+func (s *Server) stop(graceful bool) error {
+	s.mu.Lock()
+	if s.status == StatusStopped {
+		s.mu.Unlock()
+		return errNotRunning
+	}
+	s.status = StatusStopping
+	instance := s.serverInstance
+	s.mu.Unlock()
+
+	var err error
+	if graceful {
+		err = instance.ShutDownServerInstance()
+	} else {
+		err = instance.AbortServerInstance()
+	}
+	s.setStatus(StatusStopped)
+	return err
+}
+
+// interrupt aborts a graceful stop in progress, cutting its drain short.
+// It returns whether there was one.
+func (s *Server) interrupt() bool {
+	s.mu.Lock()
+	stopping := s.status == StatusStopping
+	instance := s.serverInstance
+	s.mu.Unlock()
+
+	if stopping {
+		instance.AbortServerInstance()
+	}
+	return stopping
+}
+
+func StartServer(serName string, sbc *SbContext) error {
+	server, err := lookupServer(serName, sbc)
+	if err != nil {
+		return err
+	}
+	server.control.Lock()
+	defer server.control.Unlock()
+
+	return server.start(sbc)
+}
+
+func StopServer(serName string, graceful bool, sbc *SbContext) error {
+	server, err := lookupServer(serName, sbc)
+	if err != nil {
+		return err
+	}
+	interrupted := !graceful && server.interrupt()
+	server.control.Lock()
+	defer server.control.Unlock()
+
+	err = server.stop(graceful)
+	if err == errNotRunning && interrupted {
+		return nil
+	}
+	if err == errNotRunning {
+		return errors.New("server " + serName + " is not running")
+	}
+	return err
+}
+
+// RestartServer drains the server, if running, and starts it again with
+// the same configurations and attachments.
+func RestartServer(serName string, sbc *SbContext) error {
+	server, err := lookupServer(serName, sbc)
+	if err != nil {
+		return err
+	}
+	server.control.Lock()
+	defer server.control.Unlock()
+
+	err = server.stop(true)
+	if err != nil && err != errNotRunning {
+		return err
+	}
+	return server.start(sbc)
+}
+
+func ServerStatus(serName string, sbc *SbContext) (Status, error) {
+	server, err := lookupServer(serName, sbc)
+	if err != nil {
+		return "", err
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.status, nil
+}
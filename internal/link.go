@@ -0,0 +1,241 @@
+package serverbox
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/ramdrjn/serverbox/pkgs/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout = 5 * time.Second
+	defaultBackoffMax  = 2 * time.Minute
+)
+
+// linkOptions control how a sidecar daemon is dialled. A lazy link does
+// not wait for the daemon, it comes up whenever the daemon does.
+type linkOptions struct {
+	dialTimeout time.Duration
+	backoffMax  time.Duration
+	lazy        bool
+	tls         *tls.Config
+}
+
+func newLinkOptions(dialTimeout uint32, backoffMax uint32, lazy bool, tlsConf common.TlsConf) (linkOptions, error) {
+	opts := linkOptions{
+		dialTimeout: defaultDialTimeout,
+		backoffMax:  defaultBackoffMax,
+		lazy:        lazy,
+	}
+	if dialTimeout != 0 {
+		opts.dialTimeout = time.Duration(dialTimeout) * time.Second
+	}
+	if backoffMax != 0 {
+		opts.backoffMax = time.Duration(backoffMax) * time.Second
+	}
+	if tlsConf.Enabled {
+		var err error
+		opts.tls, err = common.ClientTlsConfig(tlsConf)
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// link is a connection to a sidecar daemon that reconnects with
+// exponential backoff and jitter, and calls connected every time it comes
+// up so the client can register again. again tells a reconnect, after the
+// link was up and went down, from a lazy link coming up the first time.
+type link struct {
+	host      string
+	opts      linkOptions
+	conn      *grpc.ClientConn
+	connected func(again bool) error
+
+	mu sync.Mutex
+	up bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func dialLink(host string, opts linkOptions) (*link, error) {
+	bc := backoff.DefaultConfig
+	bc.MaxDelay = opts.backoffMax
+
+	creds := insecure.NewCredentials()
+	if opts.tls != nil {
+		creds = credentials.NewTLS(opts.tls)
+	}
+
+	Log.Debug("dialling: ", host)
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(creds),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc,
+			MinConnectTimeout: opts.dialTimeout}))
+	if err != nil {
+		Log.Error("server connect fail: ", err)
+		return nil, err
+	}
+	l := &link{host: host, opts: opts, conn: conn,
+		done: make(chan struct{})}
+
+	if !opts.lazy {
+		err = l.wait()
+		if err != nil {
+			Log.Error("server connect fail: ", err)
+			conn.Close()
+			return nil, err
+		}
+		l.up = true
+	}
+	return l, nil
+}
+
+// wait blocks until the connection is ready or the dial timeout passes.
+func (l *link) wait() error {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		l.opts.dialTimeout)
+	defer cancel()
+
+	l.conn.Connect()
+	for {
+		st := l.conn.GetState()
+		if st == connectivity.Ready {
+			return nil
+		}
+		if !l.conn.WaitForStateChange(ctx, st) {
+			return errors.New("timed out connecting to " + l.host)
+		}
+	}
+}
+
+// start begins watching the connection, connected is called after every
+// reconnect, and once a lazy link first comes up.
+func (l *link) start(connected func(again bool) error) {
+	l.connected = connected
+	l.wg.Add(1)
+	go l.watch()
+}
+
+func (l *link) close() {
+	close(l.done)
+	l.conn.Close()
+	l.wg.Wait()
+}
+
+func (l *link) ready() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.up
+}
+
+func (l *link) setReady(up bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.up = up
+}
+
+func (l *link) watch() {
+	defer l.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	again := l.ready()
+	retry := backoff.DefaultConfig.BaseDelay
+	for {
+		st := l.conn.GetState()
+		switch st {
+		case connectivity.Ready:
+			if l.ready() {
+				break
+			}
+			if !l.onConnected(again) {
+				//the daemon is up but refused the client, retry
+				//while the connection stays up
+				select {
+				case <-l.done:
+					return
+				case <-time.After(jitter(retry)):
+				}
+				retry *= 2
+				if retry > l.opts.backoffMax {
+					retry = l.opts.backoffMax
+				}
+				continue
+			}
+			retry = backoff.DefaultConfig.BaseDelay
+			again = true
+			l.setReady(true)
+			Log.Info("connected to ", l.host)
+		case connectivity.Idle:
+			l.conn.Connect()
+			fallthrough
+		default:
+			if l.ready() {
+				l.setReady(false)
+				Log.Error("lost connection to ", l.host)
+			}
+		}
+		if !l.conn.WaitForStateChange(ctx, st) {
+			return
+		}
+	}
+}
+
+func (l *link) onConnected(again bool) bool {
+	if l.connected == nil {
+		return true
+	}
+	err := l.connected(again)
+	if err != nil {
+		Log.Error("registration with ", l.host, " failed: ", err)
+		return false
+	}
+	return true
+}
+
+// unreachable tells whether err means the daemon could not be reached, as
+// opposed to it refusing the request.
+func unreachable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// forgotten tells whether err means the daemon no longer holds the
+// registration of the client, it restarted or let the lease expire.
+func forgotten(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.FailedPrecondition:
+		return true
+	}
+	return false
+}
+
+// jitter spreads d by up to a fifth either way.
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
@@ -0,0 +1,107 @@
+package serverbox
+
+import (
+	"fmt"
+	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultBounds are the histogram bucket upper bounds used when none are
+// given, suited to latencies in seconds.
+var defaultBounds = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5,
+	5, 10}
+
+// metrics accumulates metric updates between two pushes to the statistics
+// daemon. Updates only take a mutex so they are safe on request paths.
+type metrics struct {
+	mu     sync.Mutex
+	series map[string]*pb.Metric
+}
+
+func newMetrics() *metrics {
+	return &metrics{series: make(map[string]*pb.Metric)}
+}
+
+func metricKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, n := range names {
+		fmt.Fprintf(&b, ",%s=%q", n, labels[n])
+	}
+	return b.String()
+}
+
+func (m *metrics) get(name string, mType pb.Metric_Type, labels map[string]string) *pb.Metric {
+	key := metricKey(name, labels)
+	metric, ok := m.series[key]
+	if !ok {
+		metric = &pb.Metric{Name: name, Type: mType, Labels: labels}
+		m.series[key] = metric
+	}
+	return metric
+}
+
+func (m *metrics) counter(name string, labels map[string]string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.get(name, pb.Metric_COUNTER, labels).Value += delta
+}
+
+func (m *metrics) gauge(name string, labels map[string]string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.get(name, pb.Metric_GAUGE, labels).Value = value
+}
+
+func (m *metrics) addGauge(name string, labels map[string]string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.get(name, pb.Metric_GAUGE, labels).Value += delta
+}
+
+func (m *metrics) observe(name string, labels map[string]string, bounds []float64, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric := m.get(name, pb.Metric_HISTOGRAM, labels)
+	if metric.BucketCounts == nil {
+		metric.Bounds = bounds
+		metric.BucketCounts = make([]uint64, len(bounds)+1)
+	}
+	i := sort.SearchFloat64s(metric.Bounds, value)
+	metric.BucketCounts[i]++
+	metric.Count++
+	metric.Sum += value
+}
+
+// flush returns the updates since the previous flush. Counters and
+// histograms restart from zero, gauges keep their value.
+func (m *metrics) flush() []*pb.Metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var batch []*pb.Metric
+	for key, metric := range m.series {
+		switch metric.Type {
+		case pb.Metric_GAUGE:
+			batch = append(batch, &pb.Metric{Name: metric.Name,
+				Type: metric.Type, Labels: metric.Labels,
+				Value: metric.Value})
+		default:
+			batch = append(batch, metric)
+			delete(m.series, key)
+		}
+	}
+	return batch
+}
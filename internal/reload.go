@@ -0,0 +1,148 @@
+package serverbox
+
+import (
+	"github.com/ramdrjn/serverbox/pkgs/common"
+	"reflect"
+	"sync"
+)
+
+// ReloadServers reads the configuration file again and applies the
+// difference: removed servers are shut down, added ones started and the
+// ones whose table changed in any way are restarted from the new table.
+// A restarted server keeps what was attached to it, and one that was
+// stopped stays stopped. A table that is not valid, or whose server fails
+// to initialize, keeps the server it was meant to replace running. The
+// servers that failed are named in the returned ServersError.
+func ReloadServers(sbc *SbContext) error {
+	var conf serverBoxConf
+	md, err := common.DecodeConfFile(sbc.ConfPath, &conf)
+	if err != nil {
+		return err
+	}
+
+	sbc.reload.Lock()
+	defer sbc.reload.Unlock()
+
+	sbc.mu.RLock()
+	current := sbc.Conf.Servers
+	sbc.mu.RUnlock()
+
+	//build and initialize everything new first, without touching the
+	//servers running
+	errs := make(ServersError)
+	built := make(map[string]*Server)
+	for name, serverConf := range conf.Servers {
+		oldConf, ok := current[name]
+		if ok && reflect.DeepEqual(serverConf, oldConf) {
+			continue
+		}
+		server, err := buildServer(name, serverConf, md)
+		if err == nil {
+			err = server.prepare(sbc.server(name))
+		}
+		if err != nil {
+			errs[name] = err
+			if ok {
+				conf.Servers[name] = oldConf
+			} else {
+				delete(conf.Servers, name)
+			}
+			continue
+		}
+		built[name] = server
+	}
+
+	//swap, the new servers are held until they start so that nothing is
+	//attached to them while they do
+	sbc.mu.Lock()
+	var old []*Server
+	stopped := make(map[string]bool)
+	for name, server := range sbc.Servers {
+		if _, ok := conf.Servers[name]; !ok {
+			Log.Info("reload: removing server ", name)
+			delete(sbc.Servers, name)
+			old = append(old, server)
+		}
+	}
+	for name, server := range built {
+		server.control.Lock()
+		if prev := sbc.Servers[name]; prev != nil {
+			Log.Info("reload: restarting server ", name)
+			prev.mu.Lock()
+			stopped[name] = prev.status == StatusStopped
+			prev.mu.Unlock()
+			old = append(old, prev)
+		} else {
+			Log.Info("reload: adding server ", name)
+		}
+		sbc.Servers[name] = server
+	}
+	sbc.Conf = conf
+	sbc.ConfMeta = md
+	sbc.mu.Unlock()
+
+	//drain outside the lock, a changed server may be taking the address
+	//of another
+	var wg sync.WaitGroup
+	for _, server := range old {
+		wg.Add(1)
+		go func(server *Server) {
+			server.close(true)
+			wg.Done()
+		}(server)
+	}
+	wg.Wait()
+
+	for name, server := range built {
+		if stopped[name] {
+			server.control.Unlock()
+			continue
+		}
+		//the server replaced may have reported the same uuid down
+		err := server.state.ReportState("maintanence")
+		if err == nil {
+			err = server.start(sbc)
+		}
+		server.control.Unlock()
+		if err != nil {
+			errs[name] = err
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// prepare initializes a built server and replays on it what was attached
+// to prev, the server it replaces, if any. On failure the server is shut
+// down again and prev, still running, registered anew if they share their
+// uuid.
+func (s *Server) prepare(prev *Server) (err error) {
+	if prev != nil && prev.uuid == s.uuid {
+		defer func() {
+			if err != nil {
+				prev.state.reconnected(true)
+			}
+		}()
+	}
+
+	err = s.initialize()
+	if err != nil {
+		return err
+	}
+	if prev == nil {
+		return nil
+	}
+	prev.control.Lock()
+	s.attachments = prev.attachments
+	prev.control.Unlock()
+	err = s.replay(s.serverInstance)
+	if err != nil {
+		s.serverInstance.AbortServerInstance()
+		ShutDownStatistics(&s.stats)
+		ShutDownState(&s.state)
+	}
+	return err
+}
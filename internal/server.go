@@ -3,129 +3,463 @@ package serverbox
 import (
 	"errors"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"google.golang.org/grpc"
+	"net"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-type ServerType uint8
-
-const (
-	invalid_server = iota
-	http_server
-)
-
-type serverInstance interface {
-	InitializeServerInstance(ServerConfigurations) error
+// ServerInstance is the kind of server a server type runs. Its factory is
+// handed the configurations of the server before InitializeServerInstance.
+type ServerInstance interface {
+	InitializeServerInstance() error
 	RunServerInstance() error
 	ShutDownServerInstance() error
 	AbortServerInstance() error
 	AttachRouterServerInstance(mux.Router) error
 }
 
+// ServerFactory creates the instance of a server of the type it is
+// registered for, conf is the configurations table of the server.
+type ServerFactory func(server *Server, conf RawConf) (ServerInstance, error)
+
+var (
+	serverTypesMu sync.RWMutex
+	serverTypes   = map[string]ServerFactory{
+		"http":  newServerHttp,
+		"https": newServerHttps,
+		"grpc":  newServerGrpc,
+		"tcp":   newServerTcp,
+		"udp":   newServerUdp,
+	}
+)
+
+// RegisterServerType makes a server type available to the configuration,
+// it has to be called before the servers are initialized.
+func RegisterServerType(name string, factory ServerFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("server type needs a name and a factory")
+	}
+
+	serverTypesMu.Lock()
+	defer serverTypesMu.Unlock()
+
+	if _, ok := serverTypes[name]; ok {
+		return errors.New("server type " + name + " already registered")
+	}
+	serverTypes[name] = factory
+	return nil
+}
+
+type grpcServiceAttacher interface {
+	AttachGrpcServiceServerInstance(*grpc.ServiceDesc, interface{}) error
+}
+
+type connHandlerAttacher interface {
+	AttachConnHandlerServerInstance(ConnHandler) error
+}
+
+type packetHandlerAttacher interface {
+	AttachPacketHandlerServerInstance(PacketHandler) error
+}
+
 type Server struct {
 	name           string
-	sType          ServerType
+	sType          string
 	uuid           string
 	bindIp         string
 	bindPort       uint16
+	bindUnix       *unixSocket
 	stats          Statistics
 	state          State
-	serverInstance serverInstance
+	serverInstance ServerInstance
 	enabled        bool
+	startTimeout   time.Duration
+	drainTimeout   time.Duration
+	conf           RawConf
+	table          server
+	statsOpts      linkOptions
+	stateOpts      linkOptions
+
+	//control serializes starting, stopping and attaching
+	control     sync.Mutex
+	attachments []func(ServerInstance) error
+
+	mu     sync.Mutex
+	status Status
+	ran    bool
+	run    int
+	bound  chan struct{}
 }
 
-func convertServerType(sType string) (ServerType, error) {
-	switch sType {
-	case "http":
-		return http_server, nil
+const (
+	defaultStartTimeout = 10 * time.Second
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// ServersError names each server that failed to start.
+type ServersError map[string]error
+
+func (e ServersError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
 	}
-	return invalid_server, errors.New("invalid server type")
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(e))
+	for _, name := range names {
+		msgs = append(msgs, name+": "+e[name].Error())
+	}
+	return strings.Join(msgs, "; ")
 }
 
-func getServerInstance(s *Server) (serverInstance, error) {
-	switch s.sType {
-	case http_server:
-		return &ServerHttp{server: s}, nil
+// ServerError is sent on SbContext.Errors when a server stops on its own
+// after it started.
+type ServerError struct {
+	Server string
+	Err    error
+}
+
+func (e *ServerError) Error() string {
+	return e.Server + ": " + e.Err.Error()
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+func getServerInstance(s *Server, conf RawConf) (ServerInstance, error) {
+	serverTypesMu.RLock()
+	factory, ok := serverTypes[s.sType]
+	serverTypesMu.RUnlock()
+	if !ok {
+		return nil, errors.New("invalid server type " + s.sType)
 	}
-	return nil, errors.New("invalid server type")
+	return factory(s, conf)
 }
 
-func generateUuid(name string, ip string, port uint16) (string, error) {
-	return fmt.Sprintf("%s@%s:%d", name, ip, port), nil
+func (s *Server) Name() string {
+	return s.name
 }
 
-func InitializeServers(sbc *SbContext) (err error) {
-	sbc.Servers = make(map[string]*Server)
+func (s *Server) Uuid() string {
+	return s.uuid
+}
 
-	for serverName, serverConf := range sbc.Conf.Servers {
-		server := new(Server)
-		server.name = serverName
-		server.bindIp = serverConf.Bind_ip
-		server.bindPort = serverConf.Bind_port
+func (s *Server) Statistics() *Statistics {
+	return &s.stats
+}
+
+func (s *Server) State() *State {
+	return &s.state
+}
+
+// DrainTimeout is how long a graceful shut down waits for the requests in
+// flight before closing them.
+func (s *Server) DrainTimeout() time.Duration {
+	return s.drainTimeout
+}
+
+// draining tells the state daemon the server is draining. Failing to do
+// so does not hold the shut down up.
+func (s *Server) draining() {
+	err := s.state.ReportState("draining")
+	if err != nil {
+		Log.Error("draining not reported for ", s.name, ": ", err)
+	}
+}
+
+// drainExpired logs a drain that ran out of time and is being cut short.
+func (s *Server) drainExpired() {
+	Log.Errorf("server %s not drained within %s, closing", s.name,
+		s.drainTimeout)
+}
+
+// down reports the server down once it stopped, err being how it
+// stopped.
+func (s *Server) down(err error) error {
+	derr := s.state.ReportState("down")
+	if err != nil {
+		return err
+	}
+	return derr
+}
+
+// waitTimeout waits for wg, it returns false when d runs out first.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Bound tells Run that the server accepts requests. Listen and
+// ListenPacket call it, an instance that binds otherwise has to.
+func (s *Server) Bound() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bound == nil {
+		return
+	}
+	select {
+	case <-s.bound:
+	default:
+		close(s.bound)
+		if s.status == StatusStarting {
+			s.status = StatusRunning
+		}
+	}
+}
+
+// Listen binds the address of the server, a unix socket or tcp.
+func (s *Server) Listen() (ln net.Listener, err error) {
+	if s.bindUnix != nil {
+		ln, err = s.bindUnix.listen()
+	} else {
+		ln, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.bindIp,
+			s.bindPort))
+	}
+	if err == nil {
+		s.Bound()
+	}
+	return ln, err
+}
+
+// ListenPacket binds the address of the server, a unix datagram socket or
+// udp.
+func (s *Server) ListenPacket() (pc net.PacketConn, err error) {
+	if s.bindUnix != nil {
+		pc, err = s.bindUnix.listenPacket()
+	} else {
+		pc, err = net.ListenPacket("udp", fmt.Sprintf("%s:%d",
+			s.bindIp, s.bindPort))
+	}
+	if err == nil {
+		s.Bound()
+	}
+	return pc, err
+}
+
+// generateUuid names a server bound to a unix socket name@unix:path.
+func generateUuid(name string, ip string, port uint16, unixPath string) (string, error) {
+	if unixPath != "" {
+		return fmt.Sprintf("%s@unix:%s", name, unixPath), nil
+	}
+	return fmt.Sprintf("%s@%s:%d", name, ip, port), nil
+}
 
-		server.sType, err = convertServerType(serverConf.Type)
+// newServer builds and initializes the server name of serverConf, md is
+// the metadata of the file serverConf was decoded from.
+func newServer(name string, serverConf server, md toml.MetaData) (*Server, error) {
+	server, err := buildServer(name, serverConf, md)
+	if err != nil {
+		return nil, err
+	}
+	err = server.initialize()
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// buildServer checks serverConf and creates the instance of the server,
+// without linking to the daemons or initializing the instance yet.
+func buildServer(name string, serverConf server, md toml.MetaData) (*Server, error) {
+	var err error
+	server := new(Server)
+	server.name = name
+	server.table = serverConf
+	server.bindIp = serverConf.Bind_ip
+	server.bindPort = serverConf.Bind_port
+	server.status = StatusStopped
+	server.startTimeout = defaultStartTimeout
+	if serverConf.Start_timeout != 0 {
+		server.startTimeout = time.Duration(
+			serverConf.Start_timeout) * time.Second
+	}
+	server.drainTimeout = defaultDrainTimeout
+	if serverConf.Drain_timeout != 0 {
+		server.drainTimeout = time.Duration(
+			serverConf.Drain_timeout) * time.Second
+	}
+	if serverConf.Bind_unix != "" {
+		server.bindUnix, err = newUnixSocket(serverConf.Bind_unix,
+			serverConf.Unix_mode, serverConf.Unix_owner)
 		if err != nil {
-			break
+			return nil, err
 		}
+	}
 
-		server.uuid, _ = generateUuid(serverName, server.bindIp,
-			server.bindPort)
-
-		statsConf := serverConf.Statistics
-		if statsConf.Enabled {
-			host := fmt.Sprintf("%s:%d", statsConf.Host,
-				statsConf.Port)
-			err = InitializeStatistics(server.uuid,
-				host, &server.stats)
-			if err != nil {
-				break
-			}
+	server.sType = serverConf.Type
+
+	server.uuid, _ = generateUuid(name, server.bindIp,
+		server.bindPort, serverConf.Bind_unix)
+
+	statsConf := serverConf.Statistics
+	if statsConf.Enabled {
+		server.statsOpts, err = newLinkOptions(statsConf.Dial_timeout,
+			statsConf.Backoff_max, statsConf.Lazy, statsConf.Tls)
+		if err != nil {
+			return nil, err
 		}
-		stateConf := serverConf.State
-		if stateConf.Enabled {
-			host := fmt.Sprintf("%s:%d", stateConf.Host,
-				stateConf.Port)
-			err = InitializeState(server.uuid,
-				host, &server.state)
-			if err != nil {
-				break
-			}
+	}
+	stateConf := serverConf.State
+	if stateConf.Enabled {
+		server.stateOpts, err = newLinkOptions(stateConf.Dial_timeout,
+			stateConf.Backoff_max, stateConf.Lazy, stateConf.Tls)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		server.serverInstance, err = getServerInstance(server)
+	server.conf = RawConf{md: md,
+		prim:    serverConf.Configurations,
+		defined: md.IsDefined("servers", name, "configurations")}
+	server.serverInstance, err = getServerInstance(server, server.conf)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// initialize links a built server to the daemons and initializes its
+// instance.
+func (s *Server) initialize() (err error) {
+	defer func() {
 		if err != nil {
-			break
+			ShutDownStatistics(&s.stats)
+			ShutDownState(&s.state)
 		}
+	}()
 
-		err = server.serverInstance.InitializeServerInstance(serverConf.Configurations)
+	statsConf := s.table.Statistics
+	if statsConf.Enabled {
+		host := fmt.Sprintf("%s:%d", statsConf.Host,
+			statsConf.Port)
+		err = InitializeStatistics(s.uuid, host, statsConf.Interval,
+			statsConf.Buffer, s.statsOpts, &s.stats)
 		if err != nil {
-			break
+			return err
 		}
+	}
+	stateConf := s.table.State
+	if stateConf.Enabled {
+		host := fmt.Sprintf("%s:%d", stateConf.Host,
+			stateConf.Port)
+		err = InitializeState(s.uuid, host, stateConf.Ttl, s.stateOpts,
+			&s.state)
+		if err != nil {
+			return err
+		}
+	}
 
-		server.enabled = true
+	err = s.serverInstance.InitializeServerInstance()
+	if err != nil {
+		return err
+	}
+
+	s.enabled = true
+	return nil
+}
+
+func InitializeServers(sbc *SbContext) (err error) {
+	sbc.Servers = make(map[string]*Server)
+	sbc.Errors = make(chan error, len(sbc.Conf.Servers))
+
+	for serverName, serverConf := range sbc.Conf.Servers {
+		var server *Server
+		server, err = newServer(serverName, serverConf, sbc.ConfMeta)
+		if err != nil {
+			break
+		}
 		sbc.Servers[serverName] = server
 	}
 	return err
 }
 
+// RunServers returns once every server is bound, or with a ServersError
+// naming those that failed or did not bind within their start timeout.
+// The servers that did start keep running. A server that stops on its own
+// later is reported on sbc.Errors.
 func RunServers(sbc *SbContext) error {
-	for _, server := range sbc.Servers {
-		go server.serverInstance.RunServerInstance()
+	type result struct {
+		name string
+		err  error
+	}
+	sbc.mu.RLock()
+	defer sbc.mu.RUnlock()
+
+	results := make(chan result, len(sbc.Servers))
+
+	for name, server := range sbc.Servers {
+		go func(name string, server *Server) {
+			server.control.Lock()
+			defer server.control.Unlock()
+
+			results <- result{name, server.start(sbc)}
+		}(name, server)
+	}
+
+	errs := make(ServersError)
+	for range sbc.Servers {
+		r := <-results
+		if r.err != nil {
+			errs[r.name] = r.err
+		}
+	}
+	if len(errs) != 0 {
+		return errs
 	}
 	return nil
 }
 
+// close stops the server and its statistics and state links.
+func (s *Server) close(graceful bool) {
+	if !graceful {
+		s.interrupt()
+	}
+	s.control.Lock()
+	err := s.stop(graceful)
+	if err == errNotRunning {
+		//never started or already exited, release the instance and
+		//still report the server down
+		s.mu.Lock()
+		instance := s.serverInstance
+		s.mu.Unlock()
+		instance.AbortServerInstance()
+	}
+	s.control.Unlock()
+	ShutDownStatistics(&s.stats)
+	ShutDownState(&s.state)
+}
+
 func ShutDownServers(sbc *SbContext) error {
+	sbc.mu.RLock()
+	defer sbc.mu.RUnlock()
+
 	var wg sync.WaitGroup
 	for _, server := range sbc.Servers {
 		// Increment the WaitGroup counter.
 		wg.Add(1)
-		go func() {
-			server.serverInstance.ShutDownServerInstance()
-			ShutDownStatistics(&server.stats)
-			ShutDownState(&server.state)
+		go func(server *Server) {
+			server.close(true)
 			wg.Done()
-		}()
+		}(server)
 	}
 	// Wait for all HTTP fetches to complete.
 	wg.Wait()
@@ -133,18 +467,66 @@ func ShutDownServers(sbc *SbContext) error {
 }
 
 func AbortServers(sbc *SbContext) (err error) {
+	sbc.mu.RLock()
+	defer sbc.mu.RUnlock()
+
 	for _, server := range sbc.Servers {
-		server.serverInstance.AbortServerInstance()
-		ShutDownStatistics(&server.stats)
-		ShutDownState(&server.state)
+		server.close(false)
 	}
 	return nil
 }
 
 func AttachRouterToServer(router mux.Router, serName string, sbc *SbContext) (err error) {
-	server := sbc.Servers[serName]
+	server := sbc.server(serName)
 	if server != nil {
-		err = server.serverInstance.AttachRouterServerInstance(router)
+		err = server.attach(func(instance ServerInstance) error {
+			return instance.AttachRouterServerInstance(router)
+		})
+	}
+	return err
+}
+
+func AttachGrpcServiceToServer(desc *grpc.ServiceDesc, impl interface{}, serName string, sbc *SbContext) (err error) {
+	server := sbc.server(serName)
+	if server != nil {
+		err = server.attach(func(instance ServerInstance) error {
+			attacher, ok := instance.(grpcServiceAttacher)
+			if !ok {
+				return errors.New("server " + serName +
+					" does not host grpc services")
+			}
+			return attacher.AttachGrpcServiceServerInstance(desc, impl)
+		})
+	}
+	return err
+}
+
+func AttachConnHandlerToServer(handler ConnHandler, serName string, sbc *SbContext) (err error) {
+	server := sbc.server(serName)
+	if server != nil {
+		err = server.attach(func(instance ServerInstance) error {
+			attacher, ok := instance.(connHandlerAttacher)
+			if !ok {
+				return errors.New("server " + serName +
+					" does not take connection handlers")
+			}
+			return attacher.AttachConnHandlerServerInstance(handler)
+		})
+	}
+	return err
+}
+
+func AttachPacketHandlerToServer(handler PacketHandler, serName string, sbc *SbContext) (err error) {
+	server := sbc.server(serName)
+	if server != nil {
+		err = server.attach(func(instance ServerInstance) error {
+			attacher, ok := instance.(packetHandlerAttacher)
+			if !ok {
+				return errors.New("server " + serName +
+					" does not take packet handlers")
+			}
+			return attacher.AttachPacketHandlerServerInstance(handler)
+		})
 	}
 	return err
 }
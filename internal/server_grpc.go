@@ -0,0 +1,126 @@
+package serverbox
+
+import (
+	"errors"
+	"github.com/ramdrjn/serverbox/pkgs/common"
+	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type ServerGrpc struct {
+	server     *Server
+	conf       ServerConfigurations
+	grpcServer *grpc.Server
+
+	mu      sync.Mutex
+	serving bool
+}
+
+func newServerGrpc(server *Server, conf RawConf) (ServerInstance, error) {
+	s := &ServerGrpc{server: server}
+	return s, conf.Decode(&s.conf)
+}
+
+func (s *ServerGrpc) InitializeServerInstance() (err error) {
+	sc := s.conf
+	opts := grpcInterceptors(&s.server.stats)
+	if sc.Grpc.Tls.Enabled {
+		config, err := common.ServerTlsConfig(sc.Grpc.Tls)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+
+	err = s.server.stats.RegisterForStats()
+	if err != nil {
+		return err
+	}
+	err = s.server.state.RegisterForState()
+	if err != nil {
+		return err
+	}
+
+	return s.server.state.ReportState("maintanence")
+}
+
+func (s *ServerGrpc) RunServerInstance() error {
+	ln, err := s.server.Listen()
+	if err != nil {
+		Log.Error(err)
+		s.server.state.ReportState("down")
+		return err
+	}
+	err = s.server.state.ReportState("up")
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.serving = true
+	s.mu.Unlock()
+
+	err = s.grpcServer.Serve(ln)
+	if err != nil {
+		Log.Error(err)
+		s.server.state.ReportState("down")
+	}
+	return err
+}
+
+// ShutDownServerInstance lets the calls in flight finish, stopping those
+// still running after the drain timeout.
+func (s *ServerGrpc) ShutDownServerInstance() error {
+	s.server.draining()
+
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	timer := time.NewTimer(s.server.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		s.server.drainExpired()
+		s.grpcServer.Stop()
+		<-done
+	}
+	return s.server.down(nil)
+}
+
+func (s *ServerGrpc) AbortServerInstance() error {
+	s.grpcServer.Stop()
+	return s.server.down(nil)
+}
+
+func (s *ServerGrpc) AttachRouterServerInstance(router mux.Router) error {
+	return errors.New("grpc server " + s.server.name +
+		" does not take http routes")
+}
+
+// AttachGrpcServiceServerInstance registers a service, which grpc only
+// allows before the server runs.
+func (s *ServerGrpc) AttachGrpcServiceServerInstance(desc *grpc.ServiceDesc, impl interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.serving {
+		return errors.New("grpc server " + s.server.name +
+			" is already running")
+	}
+	ht := reflect.TypeOf(desc.HandlerType).Elem()
+	if !reflect.TypeOf(impl).Implements(ht) {
+		return errors.New(desc.ServiceName + " is not implemented by " +
+			reflect.TypeOf(impl).String())
+	}
+	s.grpcServer.RegisterService(desc, impl)
+	return nil
+}
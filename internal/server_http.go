@@ -2,21 +2,52 @@ package serverbox
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"net"
 	"net/http"
+	"time"
 )
 
 type ServerHttp struct {
 	server     *Server
+	conf       ServerConfigurations
+	secure     bool
 	httpServer http.Server
+	reloader   *certReloader
+	redirect   *http.Server
 }
 
-func (s *ServerHttp) InitializeServerInstance(sc ServerConfigurations) (err error) {
+func newServerHttp(server *Server, conf RawConf) (ServerInstance, error) {
+	s := &ServerHttp{server: server}
+	return s, conf.Decode(&s.conf)
+}
+
+func newServerHttps(server *Server, conf RawConf) (ServerInstance, error) {
+	s := &ServerHttp{server: server, secure: true}
+	return s, conf.Decode(&s.conf)
+}
+
+func (s *ServerHttp) InitializeServerInstance() (err error) {
+	sc := s.conf
 	s.httpServer.Addr = fmt.Sprintf("%s:%d", s.server.bindIp,
 		s.server.bindPort)
 	s.httpServer.Handler = http.NewServeMux()
 
+	if s.secure {
+		err = s.initializeTls(sc.Http.Tls)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			//the instance is not going to run, stop the watch
+			if err != nil {
+				s.reloader.close()
+			}
+		}()
+	}
+
 	err = s.server.stats.RegisterForStats()
 	if err != nil {
 		return err
@@ -37,10 +68,12 @@ func (s *ServerHttp) InitializeServerInstance(sc ServerConfigurations) (err erro
 			fs := http.FileServer(http.Dir(sc.Http.Static_dir))
 			if sc.Http.Strip_path != "" {
 				mux.Handle(sc.Http.Static_path,
-					http.StripPrefix(sc.Http.Strip_path, fs))
+					instrument(&s.server.stats, sc.Http.Static_path,
+						http.StripPrefix(sc.Http.Strip_path, fs)))
 				Log.Infof("http mux set for file url path: %s with local directory path %s and strip prefix of %s", sc.Http.Static_path, sc.Http.Static_dir, sc.Http.Strip_path)
 			} else {
-				mux.Handle(sc.Http.Static_path, fs)
+				mux.Handle(sc.Http.Static_path,
+					instrument(&s.server.stats, sc.Http.Static_path, fs))
 				Log.Infof("http mux set for file url path: %s with local directory path %s", sc.Http.Static_path, sc.Http.Static_dir)
 			}
 		}
@@ -49,36 +82,123 @@ func (s *ServerHttp) InitializeServerInstance(sc ServerConfigurations) (err erro
 	return nil
 }
 
+func (s *ServerHttp) initializeTls(conf httpsConfigurations) (err error) {
+	if conf.Cert == "" || conf.Key == "" {
+		return errors.New("https needs a cert and key")
+	}
+	interval := defaultCertReloadInterval
+	if conf.Reload_interval != 0 {
+		interval = time.Duration(conf.Reload_interval) * time.Second
+	}
+	s.reloader, err = newCertReloader(conf.Cert, conf.Key, interval)
+	if err != nil {
+		return err
+	}
+	s.httpServer.TLSConfig, err = newHttpsConfig(conf, s.reloader)
+	if err != nil {
+		s.reloader.close()
+		return err
+	}
+	if conf.Redirect_port != 0 && s.server.bindUnix != nil {
+		s.reloader.close()
+		return errors.New("https redirect needs a bind_ip")
+	}
+	if conf.Redirect_port != 0 {
+		s.redirect = newRedirectServer(s.server.bindIp,
+			conf.Redirect_port, s.server.bindPort)
+	}
+	return nil
+}
+
 func (s *ServerHttp) RunServerInstance() error {
-	err := s.server.state.ReportState("up")
+	//bind the redirect first, so that failing to fails the start
+	var redirectLn net.Listener
+	if s.redirect != nil {
+		var err error
+		redirectLn, err = net.Listen("tcp", s.redirect.Addr)
+		if err != nil {
+			Log.Error("https redirect failed: ", err)
+			s.server.state.ReportState("down")
+			return err
+		}
+	}
+	ln, err := s.server.Listen()
 	if err != nil {
+		Log.Error(err)
+		if redirectLn != nil {
+			redirectLn.Close()
+		}
+		s.server.state.ReportState("down")
+		return err
+	}
+	err = s.server.state.ReportState("up")
+	if err != nil {
+		if redirectLn != nil {
+			redirectLn.Close()
+		}
+		ln.Close()
 		return err
 	}
 
-	err = s.httpServer.ListenAndServe()
+	if s.redirect != nil {
+		go func() {
+			err := s.redirect.Serve(redirectLn)
+			if err != nil && err != http.ErrServerClosed {
+				Log.Error("https redirect failed: ", err)
+			}
+		}()
+	}
+	if s.secure {
+		err = s.httpServer.ServeTLS(ln, "", "")
+	} else {
+		err = s.httpServer.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	if err != nil {
 		Log.Error(err)
+		if s.redirect != nil {
+			s.redirect.Close()
+		}
 		s.server.state.ReportState("down")
 	}
 	return err
 }
 
+// ShutDownServerInstance lets the requests in flight finish, closing those
+// still running after the drain timeout.
 func (s *ServerHttp) ShutDownServerInstance() error {
-	err := s.server.state.ReportState("down")
-	if err != nil {
-		return err
+	s.server.draining()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		s.server.drainTimeout)
+	defer cancel()
+	if s.redirect != nil {
+		if s.redirect.Shutdown(ctx) != nil {
+			s.redirect.Close()
+		}
 	}
-	err = s.httpServer.Shutdown(context.TODO())
-	return err
+	err := s.httpServer.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		s.server.drainExpired()
+		err = s.httpServer.Close()
+	}
+	if s.reloader != nil {
+		s.reloader.close()
+	}
+	return s.server.down(err)
 }
 
 func (s *ServerHttp) AbortServerInstance() error {
-	err := s.server.state.ReportState("down")
-	if err != nil {
-		return err
+	if s.redirect != nil {
+		s.redirect.Close()
 	}
-	err = s.httpServer.Close()
-	return err
+	err := s.httpServer.Close()
+	if s.reloader != nil {
+		s.reloader.close()
+	}
+	return s.server.down(err)
 }
 
 func (s *ServerHttp) AttachRouterServerInstance(router mux.Router) error {
@@ -86,7 +206,7 @@ func (s *ServerHttp) AttachRouterServerInstance(router mux.Router) error {
 	next := router.GetRoutes()
 	pat, obj := next()
 	for pat != "" {
-		mux.Handle(pat, obj)
+		mux.Handle(pat, instrument(&s.server.stats, pat, obj))
 		pat, obj = next()
 	}
 	return nil
@@ -0,0 +1,204 @@
+package serverbox
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultCertReloadInterval = 30 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// certReloader serves the certificate in cert and key, loading it again
+// whenever either file changes so rotation needs no restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	done   chan struct{}
+	closed sync.Once
+}
+
+func newCertReloader(certFile string, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile,
+		interval: interval, done: make(chan struct{})}
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// lastModified returns the later of the modification times of the files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return last, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// watch keeps serving the previous certificate when the new files do not
+// load, as the cert and key may be caught half way through a rotation.
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			modTime, err := r.lastModified()
+			r.mu.RLock()
+			changed := err == nil && !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = r.load()
+			if err != nil {
+				Log.Error("certificate reload failed: ", err)
+				continue
+			}
+			Log.Info("certificate reloaded from ", r.certFile)
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *certReloader) close() {
+	r.closed.Do(func() { close(r.done) })
+}
+
+func newHttpsConfig(conf httpsConfigurations, reloader *certReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if conf.Min_version != "" {
+		v, ok := tlsVersions[conf.Min_version]
+		if !ok {
+			return nil, errors.New("invalid tls version " +
+				conf.Min_version)
+		}
+		config.MinVersion = v
+	}
+
+	if len(conf.Cipher_suites) != 0 {
+		ids := make(map[string]uint16)
+		for _, cs := range tls.CipherSuites() {
+			ids[cs.Name] = cs.ID
+		}
+		for _, cs := range tls.InsecureCipherSuites() {
+			ids[cs.Name] = cs.ID
+		}
+		for _, name := range conf.Cipher_suites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, errors.New("invalid cipher suite " + name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	if conf.Client_ca != "" {
+		pem, err := os.ReadFile(conf.Client_ca)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " +
+				conf.Client_ca)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if conf.Client_auth != "" {
+		auth, ok := clientAuthTypes[conf.Client_auth]
+		if !ok {
+			return nil, errors.New("invalid client auth " +
+				conf.Client_auth)
+		}
+		config.ClientAuth = auth
+	}
+	return config, nil
+}
+
+// newRedirectServer answers plain http on port with a permanent redirect
+// to the same url on the https port.
+func newRedirectServer(ip string, port uint16, httpsPort uint16) *http.Server {
+	handler := func(res http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host,
+				strconv.Itoa(int(httpsPort)))
+		}
+		url := "https://" + host + req.URL.RequestURI()
+		http.Redirect(res, req, url, http.StatusMovedPermanently)
+	}
+	return &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", ip, port),
+		Handler: http.HandlerFunc(handler),
+	}
+}
@@ -0,0 +1,238 @@
+package serverbox
+
+import (
+	"context"
+	"errors"
+	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnHandler serves the connections accepted by a tcp server. The server
+// closes the connection once ServeConn returns. ctx is cancelled when the
+// server starts draining.
+type ConnHandler interface {
+	ServeConn(ctx context.Context, conn net.Conn)
+}
+
+// deadlineConn moves the read and write deadlines forward before every
+// read and write, so they bound idle time rather than connection time.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	received     int64
+	sent         int64
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if c.readTimeout != 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	n, err := c.Conn.Read(p)
+	c.received += int64(n)
+	return n, err
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.writeTimeout != 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	n, err := c.Conn.Write(p)
+	c.sent += int64(n)
+	return n, err
+}
+
+type ServerTcp struct {
+	server       *Server
+	conf         ServerConfigurations
+	handler      ConnHandler
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	slots        chan struct{}
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newServerTcp(server *Server, conf RawConf) (ServerInstance, error) {
+	s := &ServerTcp{server: server}
+	return s, conf.Decode(&s.conf)
+}
+
+func (s *ServerTcp) InitializeServerInstance() (err error) {
+	sc := s.conf
+	s.readTimeout = time.Duration(sc.Socket.Read_timeout) * time.Second
+	s.writeTimeout = time.Duration(sc.Socket.Write_timeout) * time.Second
+	if sc.Socket.Max_connections != 0 {
+		s.slots = make(chan struct{}, sc.Socket.Max_connections)
+	}
+	s.conns = make(map[net.Conn]struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	err = s.server.stats.RegisterForStats()
+	if err != nil {
+		return err
+	}
+	err = s.server.state.RegisterForState()
+	if err != nil {
+		return err
+	}
+
+	return s.server.state.ReportState("maintanence")
+}
+
+func (s *ServerTcp) RunServerInstance() error {
+	if s.handler == nil {
+		err := errors.New("tcp server " + s.server.name +
+			" has no connection handler")
+		Log.Error(err)
+		s.server.state.ReportState("down")
+		return err
+	}
+	ln, err := s.server.Listen()
+	if err != nil {
+		Log.Error(err)
+		s.server.state.ReportState("down")
+		return err
+	}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	err = s.server.state.ReportState("up")
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			Log.Error(err)
+			s.server.state.ReportState("down")
+			return err
+		}
+		s.accept(conn)
+	}
+}
+
+// accept refuses the connection when max_connections are already open.
+func (s *ServerTcp) accept(conn net.Conn) {
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.server.stats.Counter("tcp_connections_rejected_total",
+				nil, 1)
+			conn.Close()
+			return
+		}
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		conn.Close()
+		if s.slots != nil {
+			<-s.slots
+		}
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go s.serve(conn)
+}
+
+func (s *ServerTcp) serve(conn net.Conn) {
+	stats := &s.server.stats
+	stats.Counter("tcp_connections_total", nil, 1)
+	stats.AddGauge("tcp_connections_active", nil, 1)
+	start := time.Now()
+
+	dc := &deadlineConn{Conn: conn, readTimeout: s.readTimeout,
+		writeTimeout: s.writeTimeout}
+	s.handler.ServeConn(s.ctx, dc)
+	conn.Close()
+
+	stats.AddGauge("tcp_connections_active", nil, -1)
+	stats.Counter("tcp_bytes_received_total", nil, float64(dc.received))
+	stats.Counter("tcp_bytes_sent_total", nil, float64(dc.sent))
+	stats.Observe("tcp_connection_duration_seconds", nil, nil,
+		time.Since(start).Seconds())
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	if s.slots != nil {
+		<-s.slots
+	}
+	s.wg.Done()
+}
+
+// stop closes the listener and cancels the handlers' context, aborting
+// also closes the open connections.
+func (s *ServerTcp) stop(abort bool) {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if abort {
+		for conn := range s.conns {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// ShutDownServerInstance drains, it waits for the open connections to be
+// served and closes those still open after the drain timeout.
+func (s *ServerTcp) ShutDownServerInstance() error {
+	s.server.draining()
+	s.stop(false)
+	if !waitTimeout(&s.wg, s.server.drainTimeout) {
+		s.server.drainExpired()
+		s.stop(true)
+	}
+	return s.server.down(nil)
+}
+
+func (s *ServerTcp) AbortServerInstance() error {
+	s.stop(true)
+	return s.server.down(nil)
+}
+
+func (s *ServerTcp) AttachRouterServerInstance(router mux.Router) error {
+	return errors.New("tcp server " + s.server.name +
+		" does not take http routes")
+}
+
+func (s *ServerTcp) AttachConnHandlerServerInstance(handler ConnHandler) error {
+	s.handler = handler
+	return nil
+}
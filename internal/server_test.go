@@ -0,0 +1,767 @@
+package serverbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/ramdrjn/serverbox/pkgs/common"
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	spb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	Log = common.InitializeLogger("serverbox", common.DebugLevel)
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// serveFake runs a fake daemon on addr, the same addr can be served again
+// once the returned server is stopped.
+func serveFake(t *testing.T, addr string, register func(*grpc.Server)) *grpc.Server {
+	var ln net.Listener
+	var err error
+	for i := 0; i < 20; i++ {
+		ln, err = net.Listen("tcp", addr)
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(ln)
+	return srv
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type fakeState struct {
+	pb.UnimplementedStateServer
+
+	mu            sync.Mutex
+	registrations int
+	reports       []pb.ReportReq_State
+	states        map[string]pb.ReportReq_State
+	expired       bool
+	refuse        int
+	reject        bool
+}
+
+func (f *fakeState) RegisterForState(ctx context.Context, req *pb.RegisterReq) (*pb.RegisterRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.registrations++
+	if f.refuse > 0 {
+		f.refuse--
+		return nil, status.Error(codes.PermissionDenied, "refused")
+	}
+	f.expired = false
+	return &pb.RegisterRes{}, nil
+}
+
+func (f *fakeState) ReportState(ctx context.Context, req *pb.ReportReq) (*pb.ReportRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reject {
+		return nil, status.Error(codes.FailedPrecondition, "rejected")
+	}
+	f.reports = append(f.reports, req.State)
+	if f.states == nil {
+		f.states = make(map[string]pb.ReportReq_State)
+	}
+	f.states[req.TargetUuid] = req.State
+	return &pb.ReportRes{}, nil
+}
+
+func (f *fakeState) Heartbeat(ctx context.Context, req *pb.HeartbeatReq) (*pb.HeartbeatRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.expired {
+		return nil, status.Error(codes.FailedPrecondition,
+			"lease expired")
+	}
+	return &pb.HeartbeatRes{}, nil
+}
+
+func (f *fakeState) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expired = true
+	f.reports = nil
+}
+
+func (f *fakeState) stateOf(uuid string) pb.ReportReq_State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.states[uuid]
+}
+
+func (f *fakeState) rejectReports() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reject = true
+}
+
+func (f *fakeState) registered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.registrations
+}
+
+func (f *fakeState) reported() []pb.ReportReq_State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]pb.ReportReq_State(nil), f.reports...)
+}
+
+func sameStates(a []pb.ReportReq_State, b ...pb.ReportReq_State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestState(t *testing.T, addr string, ttl uint32, lazy bool) *State {
+	opts, err := newLinkOptions(1, 1, lazy, common.TlsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := new(State)
+	err = InitializeState("web@test", addr, ttl, opts, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ShutDownState(st) })
+	return st
+}
+
+func TestStateLeaseLost(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+
+	st := newTestState(t, addr, 1, false)
+	eventually(t, "link", st.link.ready)
+	err := st.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []string{"maintanence", "up"} {
+		err = st.ReportState(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake.expire()
+	eventually(t, "registering again", func() bool {
+		return fake.registered() == 2 &&
+			sameStates(fake.reported(), pb.ReportReq_MAINTANENCE,
+				pb.ReportReq_UP)
+	})
+}
+
+func findMetric(batch []*spb.Metric, name string, labels map[string]string) *spb.Metric {
+	key := metricKey(name, labels)
+	for _, m := range batch {
+		if metricKey(m.Name, m.Labels) == key {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestInstrument(t *testing.T) {
+	stats := &Statistics{enabled: true, metrics: newMetrics()}
+	mux := http.NewServeMux()
+	mux.Handle("/ok", instrument(stats, "/ok", http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			io.WriteString(res, "ok")
+		})))
+	mux.Handle("/missing", instrument(stats, "/missing",
+		http.NotFoundHandler()))
+	mux.Handle("/hijack", instrument(stats, "/hijack", http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			hj, ok := res.(http.Hijacker)
+			if !ok {
+				t.Error("instrumented writer hides http.Hijacker")
+				return
+			}
+			conn, buf, err := hj.Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\n" +
+				"Connection: close\r\n\r\nhijacked")
+			buf.Flush()
+		})))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, path := range []string{"/ok", "/ok", "/missing", "/hijack"} {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if path == "/hijack" && string(body) != "hijacked" {
+			t.Errorf("unexpected hijacked response %q", body)
+		}
+	}
+
+	batch := stats.metrics.flush()
+	requests := func(route string, code string) float64 {
+		m := findMetric(batch, "http_requests_total", map[string]string{
+			"route": route, "method": "GET", "code": code})
+		if m == nil {
+			return 0
+		}
+		return m.Value
+	}
+	if n := requests("/ok", "2xx"); n != 2 {
+		t.Errorf("expected 2 ok requests, got %v", n)
+	}
+	if n := requests("/missing", "4xx"); n != 1 {
+		t.Errorf("expected 1 missing request, got %v", n)
+	}
+	if n := requests("/hijack", "1xx"); n != 1 {
+		t.Errorf("expected 1 hijacked request, got %v", n)
+	}
+	latency := findMetric(batch, "http_request_duration_seconds",
+		map[string]string{"route": "/ok"})
+	if latency == nil || latency.Type != spb.Metric_HISTOGRAM ||
+		latency.Count != 2 || len(latency.BucketCounts) !=
+		len(defaultBounds)+1 {
+		t.Errorf("unexpected latency series %v", latency)
+	}
+	inFlight := findMetric(batch, "http_requests_in_flight",
+		map[string]string{"route": "/ok"})
+	if inFlight == nil || inFlight.Value != 0 {
+		t.Errorf("unexpected in flight series %v", inFlight)
+	}
+}
+
+func TestLinkReconnect(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+
+	st := newTestState(t, addr, 0, false)
+	eventually(t, "link", st.link.ready)
+	err := st.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Stop()
+	eventually(t, "link down", func() bool { return !st.link.ready() })
+
+	restarted := &fakeState{}
+	srv = serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, restarted)
+	})
+	defer srv.Stop()
+	eventually(t, "registering with the restarted daemon", func() bool {
+		return restarted.registered() == 1
+	})
+	eventually(t, "link", st.link.ready)
+	if fake.registered() != 1 {
+		t.Errorf("expected 1 registration before the restart, got %d",
+			fake.registered())
+	}
+}
+
+func TestLinkRegistersOnce(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+
+	//registering before the link is watched must not be repeated when
+	//the watch first sees it up
+	st := newTestState(t, addr, 0, false)
+	err := st.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := fake.registered(); n != 1 {
+		t.Errorf("expected 1 registration, got %d", n)
+	}
+}
+
+func TestLinkLazy(t *testing.T) {
+	addr := freeAddr(t)
+	opts, err := newLinkOptions(1, 1, false, common.TlsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitializeState("web@test", addr, 0, opts, new(State))
+	if err == nil {
+		t.Fatal("eager link came up without a daemon")
+	}
+
+	st := newTestState(t, addr, 0, true)
+	err = st.RegisterForState()
+	if err != nil {
+		t.Fatal("lazy registration failed: ", err)
+	}
+	if st.link.ready() {
+		t.Fatal("link up without a daemon")
+	}
+
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+	eventually(t, "registering once the daemon is up", func() bool {
+		return fake.registered() == 1
+	})
+	eventually(t, "link", st.link.ready)
+}
+
+func TestLinkOptions(t *testing.T) {
+	opts, err := newLinkOptions(0, 0, false, common.TlsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.dialTimeout != defaultDialTimeout ||
+		opts.backoffMax != defaultBackoffMax || opts.tls != nil {
+		t.Errorf("unexpected default options %+v", opts)
+	}
+	opts, _ = newLinkOptions(2, 30, true, common.TlsConf{})
+	if opts.dialTimeout != 2*time.Second ||
+		opts.backoffMax != 30*time.Second || !opts.lazy {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter %s out of bounds", d)
+		}
+	}
+}
+
+func TestLinkRetriesRefusedRegistration(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+
+	st := newTestState(t, addr, 0, false)
+	eventually(t, "link", st.link.ready)
+	err := st.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//drop the connection so the link registers again, refused twice
+	fake.mu.Lock()
+	fake.refuse = 2
+	fake.mu.Unlock()
+	srv.Stop()
+	eventually(t, "link down", func() bool { return !st.link.ready() })
+	srv = serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+
+	eventually(t, "registration accepted", st.link.ready)
+	if n := fake.registered(); n != 4 {
+		t.Errorf("expected 2 refused and 2 accepted registrations, got %d",
+			n)
+	}
+}
+
+func TestStateQueuedReports(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+
+	st := newTestState(t, addr, 0, false)
+	eventually(t, "link", st.link.ready)
+	err := st.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.ReportState("maintanence")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Stop()
+	eventually(t, "link down", func() bool { return !st.link.ready() })
+	for _, state := range []string{"up", "draining"} {
+		err = st.ReportState(state)
+		if err != nil {
+			t.Fatalf("report of %s not queued: %v", state, err)
+		}
+	}
+	if n := st.Dropped(); n != 1 {
+		t.Errorf("expected the queued up to be superseded, got %d", n)
+	}
+
+	restarted := &fakeState{}
+	srv = serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, restarted)
+	})
+	defer srv.Stop()
+	eventually(t, "queued report replayed", func() bool {
+		return sameStates(restarted.reported(), pb.ReportReq_MAINTANENCE,
+			pb.ReportReq_UP, pb.ReportReq_DRAINING)
+	})
+	if !sameStates(fake.reported(), pb.ReportReq_MAINTANENCE) {
+		t.Errorf("unexpected reports before the restart %v",
+			fake.reported())
+	}
+
+	st.mu.Lock()
+	pending := st.pending
+	st.mu.Unlock()
+	if pending || st.Dropped() != 1 {
+		t.Errorf("queue not cleared after the replay")
+	}
+}
+
+type fakeStats struct {
+	spb.UnimplementedStatisticsServer
+
+	mu            sync.Mutex
+	registrations int
+	batches       []*spb.MetricBatch
+}
+
+func (f *fakeStats) RegisterForStats(ctx context.Context, req *spb.RegisterReq) (*spb.RegisterRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.registrations++
+	return &spb.RegisterRes{Enrolled: true}, nil
+}
+
+func (f *fakeStats) PushMetrics(stream spb.Statistics_PushMetricsServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&spb.PushRes{})
+		}
+		if err != nil {
+			return err
+		}
+		f.mu.Lock()
+		f.batches = append(f.batches, batch)
+		f.mu.Unlock()
+	}
+}
+
+// received names each batch received after its first metric, with its n
+// label when it has one.
+func (f *fakeStats) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var names []string
+	for _, b := range f.batches {
+		m := b.Metrics[0]
+		names = append(names, m.Name+m.Labels["n"])
+	}
+	return names
+}
+
+func TestStatisticsQueue(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeStats{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		spb.RegisterStatisticsServer(g, fake)
+	})
+
+	opts, err := newLinkOptions(1, 1, false, common.TlsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := new(Statistics)
+	err = InitializeStatistics("web@test", addr, 3600, 2, opts, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDownStatistics(stats)
+	//push by hand rather than from the run goroutine
+	stats.running.Do(func() {})
+	eventually(t, "link", stats.link.ready)
+	err = stats.RegisterForStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats.Counter("c", map[string]string{"n": "0"}, 1)
+	stats.push()
+
+	srv.Stop()
+	eventually(t, "link down", func() bool { return !stats.link.ready() })
+	for _, n := range []string{"1", "2", "3"} {
+		stats.Counter("c", map[string]string{"n": n}, 1)
+		stats.push()
+	}
+	if n := stats.Dropped(); n != 1 {
+		t.Errorf("expected the oldest batch dropped, got %d", n)
+	}
+
+	restarted := &fakeStats{}
+	srv = serveFake(t, addr, func(g *grpc.Server) {
+		spb.RegisterStatisticsServer(g, restarted)
+	})
+	defer srv.Stop()
+	eventually(t, "link", stats.link.ready)
+	stats.push()
+
+	got := strings.Join(restarted.received(), " ")
+	want := "c2 c3 serverbox_metric_batches_dropped_total"
+	if got != want {
+		t.Errorf("expected batches %q after the restart, got %q", want, got)
+	}
+	if got := strings.Join(fake.received(), " "); got != "c0" {
+		t.Errorf("unexpected batches before the restart %q", got)
+	}
+	restarted.mu.Lock()
+	defer restarted.mu.Unlock()
+	if restarted.registrations != 1 {
+		t.Errorf("expected to register with the restarted daemon")
+	}
+}
+
+type discardHandler struct{}
+
+func (discardHandler) ServeConn(ctx context.Context, conn net.Conn) {
+	io.Copy(io.Discard, conn)
+}
+
+func (discardHandler) ServePacket(ctx context.Context, conn net.PacketConn, addr net.Addr, packet []byte) {
+}
+
+func TestSocketWithoutHandler(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+
+	opts, err := newLinkOptions(1, 1, false, common.TlsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{name: "echo", bindIp: "localhost"}
+	err = InitializeState("echo@test", addr, 0, opts, &server.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDownState(&server.state)
+	err = server.state.RegisterForState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, instance := range []ServerInstance{&ServerTcp{server: server},
+		&ServerUdp{server: server}} {
+		err = instance.RunServerInstance()
+		if err == nil {
+			t.Errorf("%T ran without a handler", instance)
+		}
+	}
+	if !sameStates(fake.reported(), pb.ReportReq_DOWN,
+		pb.ReportReq_DOWN) {
+		t.Errorf("expected down reports, got %v", fake.reported())
+	}
+}
+
+func TestShutDownIdleServer(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+	host, port, _ := net.SplitHostPort(addr)
+	_, bindPort, _ := net.SplitHostPort(freeAddr(t))
+
+	file := filepath.Join(t.TempDir(), "idle.conf")
+	err := os.WriteFile(file, []byte(fmt.Sprintf(`
+[servers.idle]
+  bind_ip = "localhost"
+  bind_port = %s
+  type = "http"
+
+  [servers.idle.state]
+    host = %q
+    port = %s
+    enabled = true
+`, bindPort, host, port)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc := new(SbContext)
+	sbc.ConfMeta, err = common.DecodeConfFile(file, &sbc.Conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitializeServers(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a server that was never started is still reported down
+	ShutDownServers(sbc)
+	uuid := sbc.Servers["idle"].Uuid()
+	if st := fake.stateOf(uuid); st != pb.ReportReq_DOWN {
+		t.Errorf("expected idle server down, got %s", st)
+	}
+}
+
+func TestAbortReportFails(t *testing.T) {
+	addr := freeAddr(t)
+	fake := &fakeState{}
+	srv := serveFake(t, addr, func(g *grpc.Server) {
+		pb.RegisterStateServer(g, fake)
+	})
+	defer srv.Stop()
+	host, port, _ := net.SplitHostPort(addr)
+
+	var conf strings.Builder
+	addrs := make(map[string]string)
+	for _, kind := range []string{"http", "grpc", "tcp", "udp"} {
+		addrs[kind] = freeAddr(t)
+		_, bindPort, _ := net.SplitHostPort(addrs[kind])
+		fmt.Fprintf(&conf, `
+[servers.%[1]s]
+  bind_ip = "localhost"
+  bind_port = %[2]s
+  type = %[1]q
+
+  [servers.%[1]s.state]
+    host = %[3]q
+    port = %[4]s
+    enabled = true
+`, kind, bindPort, host, port)
+	}
+	file := filepath.Join(t.TempDir(), "abort.conf")
+	err := os.WriteFile(file, []byte(conf.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc := new(SbContext)
+	sbc.ConfMeta, err = common.DecodeConfFile(file, &sbc.Conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitializeServers(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDownServers(sbc)
+	err = AttachConnHandlerToServer(discardHandler{}, "tcp", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AttachPacketHandlerToServer(discardHandler{}, "udp", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RunServers(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "servers up", func() bool {
+		for kind := range addrs {
+			uuid := sbc.Servers[kind].Uuid()
+			if fake.stateOf(uuid) != pb.ReportReq_UP {
+				return false
+			}
+		}
+		return true
+	})
+
+	//the servers close even though the daemon rejects the down reports
+	fake.rejectReports()
+	for kind, addr := range addrs {
+		err = StopServer(kind, false, sbc)
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: expected the rejected report, got %v", kind,
+				err)
+		}
+		if kind == "udp" {
+			pc, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				t.Errorf("udp still bound: %v", err)
+				continue
+			}
+			pc.Close()
+			continue
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s still accepts connections", kind)
+		}
+	}
+}
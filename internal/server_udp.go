@@ -0,0 +1,228 @@
+package serverbox
+
+import (
+	"context"
+	"errors"
+	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultPacketSize = 65535
+
+// PacketHandler handles the datagrams received by a udp server, replies
+// are written to conn. ctx is cancelled when the server starts draining.
+type PacketHandler interface {
+	ServePacket(ctx context.Context, conn net.PacketConn, addr net.Addr, packet []byte)
+}
+
+// deadlinePacketConn moves the write deadline forward before every reply.
+type deadlinePacketConn struct {
+	net.PacketConn
+	writeTimeout time.Duration
+	stats        *Statistics
+}
+
+func (c *deadlinePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.writeTimeout != 0 {
+		c.PacketConn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	n, err := c.PacketConn.WriteTo(p, addr)
+	c.stats.Counter("udp_bytes_sent_total", nil, float64(n))
+	return n, err
+}
+
+type ServerUdp struct {
+	server       *Server
+	conf         ServerConfigurations
+	handler      PacketHandler
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	packetSize   int
+	slots        chan struct{}
+
+	mu      sync.Mutex
+	conn    net.PacketConn
+	closing bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newServerUdp(server *Server, conf RawConf) (ServerInstance, error) {
+	s := &ServerUdp{server: server}
+	return s, conf.Decode(&s.conf)
+}
+
+func (s *ServerUdp) InitializeServerInstance() (err error) {
+	sc := s.conf
+	s.readTimeout = time.Duration(sc.Socket.Read_timeout) * time.Second
+	s.writeTimeout = time.Duration(sc.Socket.Write_timeout) * time.Second
+	s.packetSize = defaultPacketSize
+	if sc.Socket.Packet_size != 0 {
+		s.packetSize = sc.Socket.Packet_size
+	}
+	if sc.Socket.Max_connections != 0 {
+		s.slots = make(chan struct{}, sc.Socket.Max_connections)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	err = s.server.stats.RegisterForStats()
+	if err != nil {
+		return err
+	}
+	err = s.server.state.RegisterForState()
+	if err != nil {
+		return err
+	}
+
+	return s.server.state.ReportState("maintanence")
+}
+
+// RunServerInstance hands each datagram to the handler in its own
+// goroutine, up to max_connections at a time. Datagrams beyond that are
+// dropped.
+func (s *ServerUdp) RunServerInstance() error {
+	if s.handler == nil {
+		err := errors.New("udp server " + s.server.name +
+			" has no packet handler")
+		Log.Error(err)
+		s.server.state.ReportState("down")
+		return err
+	}
+	pc, err := s.server.ListenPacket()
+	if err != nil {
+		Log.Error(err)
+		s.server.state.ReportState("down")
+		return err
+	}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		pc.Close()
+		return nil
+	}
+	s.conn = pc
+	s.mu.Unlock()
+
+	err = s.server.state.ReportState("up")
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	stats := &s.server.stats
+	conn := &deadlinePacketConn{PacketConn: pc,
+		writeTimeout: s.writeTimeout, stats: stats}
+	for {
+		buf := make([]byte, s.packetSize)
+		//moving the deadline under mu keeps it from undoing the one
+		//stop sets to unblock the read
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			return nil
+		}
+		if s.readTimeout != 0 {
+			pc.SetReadDeadline(time.Now().Add(s.readTimeout))
+		}
+		s.mu.Unlock()
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			Log.Error(err)
+			s.server.state.ReportState("down")
+			return err
+		}
+		stats.Counter("udp_packets_received_total", nil, 1)
+		stats.Counter("udp_bytes_received_total", nil, float64(n))
+
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				stats.Counter("udp_packets_dropped_total", nil, 1)
+				continue
+			}
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			return nil
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serve(conn, addr, buf[:n])
+	}
+}
+
+func (s *ServerUdp) serve(conn net.PacketConn, addr net.Addr, packet []byte) {
+	start := time.Now()
+	s.handler.ServePacket(s.ctx, conn, addr, packet)
+	s.server.stats.Observe("udp_packet_duration_seconds", nil, nil,
+		time.Since(start).Seconds())
+
+	if s.slots != nil {
+		<-s.slots
+	}
+	s.wg.Done()
+}
+
+// stop stops reading datagrams. The socket stays open for the replies of
+// the handlers still running unless aborting.
+func (s *ServerUdp) stop(abort bool) {
+	s.mu.Lock()
+	s.closing = true
+	if s.conn != nil {
+		if abort {
+			s.conn.Close()
+		} else {
+			//unblock the read loop without closing the socket
+			s.conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// ShutDownServerInstance drains, it waits for the running handlers before
+// closing the socket, up to the drain timeout.
+func (s *ServerUdp) ShutDownServerInstance() error {
+	s.server.draining()
+	s.stop(false)
+	if !waitTimeout(&s.wg, s.server.drainTimeout) {
+		s.server.drainExpired()
+	}
+
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+	return s.server.down(nil)
+}
+
+func (s *ServerUdp) AbortServerInstance() error {
+	s.stop(true)
+	return s.server.down(nil)
+}
+
+func (s *ServerUdp) AttachRouterServerInstance(router mux.Router) error {
+	return errors.New("udp server " + s.server.name +
+		" does not take http routes")
+}
+
+func (s *ServerUdp) AttachPacketHandlerServerInstance(handler PacketHandler) error {
+	s.handler = handler
+	return nil
+}
@@ -4,37 +4,43 @@ import (
 	"context"
 	"errors"
 	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type State struct {
 	uuid    string
-	conn    *grpc.ClientConn
+	ttl     uint32
+	link    *link
 	state   pb.StateClient
 	enabled bool
-}
-
-func InitializeState(uuid string, host string, state *State) error {
-	var opts []grpc.DialOption
+	done    chan struct{}
 
-	opts = append(opts,
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
-	opts = append(opts, grpc.WithBlock())
+	mu         sync.Mutex
+	registered bool
+	current    bool
+	last       pb.ReportReq_State
+	reported   bool
+	pending    bool
+	dropped    uint64
+	heartbeats sync.Once
+	shut       sync.Once
+}
 
-	Log.Debug("dialling: ", host)
-	conn, err := grpc.Dial(host, opts...)
+func InitializeState(uuid string, host string, ttl uint32, opts linkOptions, state *State) error {
+	l, err := dialLink(host, opts)
 	if err != nil {
-		Log.Error("server connect fail: ", err)
 		return err
 	}
 
-	cli := pb.NewStateClient(conn)
-
 	state.uuid = uuid
-	state.conn = conn
-	state.state = cli
+	state.ttl = ttl
+	state.link = l
+	state.state = pb.NewStateClient(l.conn)
 	state.enabled = true
+	state.done = make(chan struct{})
+	l.start(state.reconnected)
 
 	return err
 }
@@ -45,36 +51,138 @@ func ShutDownState(state *State) error {
 		return nil
 	}
 
-	state.conn.Close()
-
+	state.shut.Do(func() {
+		close(state.done)
+		state.link.close()
+	})
 	return nil
 }
 
+// RegisterForState fails if the daemon is unreachable, unless the link is
+// lazy, then registration happens as soon as the daemon is.
 func (s *State) RegisterForState() error {
 	if s.enabled == false {
 		return nil
 	}
-	req := &pb.RegisterReq{Uuid: s.uuid, Type: pb.RegisterReq_SERVER}
-	ctx := context.TODO()
-	_, err := s.state.RegisterForState(ctx, req)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.registered = true
+	err := s.register()
 	if err != nil {
 		Log.Error("registration failed for server")
+		if s.link.opts.lazy {
+			return nil
+		}
 	}
 	return err
 }
 
+func (s *State) register() error {
+	req := &pb.RegisterReq{Uuid: s.uuid, Type: pb.RegisterReq_SERVER,
+		Ttl: s.ttl}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		s.link.opts.dialTimeout)
+	defer cancel()
+	_, err := s.state.RegisterForState(ctx, req)
+	if err != nil {
+		return err
+	}
+	s.current = true
+	if s.ttl != 0 {
+		s.heartbeats.Do(func() { go s.heartbeat() })
+	}
+	return nil
+}
+
+// reconnected registers again, as the daemon may have restarted and
+// forgotten the server, and replays the last reported state. A lazy link
+// coming up the first time only registers if that has not happened yet.
+func (s *State) reconnected(again bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.registered || (!again && s.current) {
+		return nil
+	}
+	err := s.register()
+	if err != nil || !s.reported {
+		return err
+	}
+	for _, state := range replay(s.last) {
+		err = s.report(state)
+		if err != nil {
+			return err
+		}
+	}
+	s.pending = false
+	Log.Infof("registered again with state %s", s.last)
+	return nil
+}
+
+// replay returns the reports that take a freshly registered entity to
+// state through legal transitions.
+func replay(state pb.ReportReq_State) []pb.ReportReq_State {
+	switch state {
+	case pb.ReportReq_UP:
+		return []pb.ReportReq_State{pb.ReportReq_MAINTANENCE,
+			pb.ReportReq_UP}
+	case pb.ReportReq_DRAINING:
+		return []pb.ReportReq_State{pb.ReportReq_MAINTANENCE,
+			pb.ReportReq_UP, pb.ReportReq_DRAINING}
+	}
+	return []pb.ReportReq_State{state}
+}
+
+// heartbeat keeps the lease taken at registration alive, sending three
+// heartbeats per ttl so a single lost one does not expire it.
+func (s *State) heartbeat() {
+	ticker := time.NewTicker(time.Duration(s.ttl) * time.Second / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			req := &pb.HeartbeatReq{Uuid: s.uuid}
+			ctx, cancel := context.WithTimeout(context.Background(),
+				s.link.opts.dialTimeout)
+			_, err := s.state.Heartbeat(ctx, req)
+			cancel()
+			if forgotten(err) {
+				Log.Info("lease lost, registering again: ", err)
+				err = s.reconnected(true)
+				if err != nil {
+					Log.Error("registering again failed: ", err)
+				}
+				continue
+			}
+			if err != nil {
+				Log.Error("heartbeat failed for server: ", err)
+				continue
+			}
+			s.flush()
+		}
+	}
+}
+
 func convertState(state string) (pb.ReportReq_State, error) {
 	switch state {
 	case "up":
 		return pb.ReportReq_UP, nil
 	case "down":
 		return pb.ReportReq_DOWN, nil
-	case "maintanence":
+	case "maintanence", "maintenance":
 		return pb.ReportReq_MAINTANENCE, nil
+	case "draining":
+		return pb.ReportReq_DRAINING, nil
 	}
-	return pb.ReportReq_MAINTANENCE, errors.New("invalid state")
+	return pb.ReportReq_MAINTANENCE, errors.New("invalid state " + state)
 }
 
+// ReportState queues the report when the daemon is unreachable. Only the
+// latest queued report is kept, it is sent once the daemon is back.
 func (s *State) ReportState(state string) error {
 	if s.enabled == false {
 		return nil
@@ -82,12 +190,61 @@ func (s *State) ReportState(state string) error {
 	stateVal, err := convertState(state)
 	if err != nil {
 		Log.Error(err)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	s.last = stateVal
+	s.reported = true
+	err = s.report(stateVal)
+	if unreachable(err) {
+		Log.Infof("state unreachable, queued report of %s", state)
+		s.pending = true
+		return nil
 	}
-	req := &pb.ReportReq{TargetUuid: s.uuid, State: stateVal}
-	ctx := context.TODO()
-	_, err = s.state.ReportState(ctx, req)
 	if err != nil {
-		Log.Error("report failed for server")
+		Log.Error("report failed for server: ", err)
+		return err
+	}
+	s.pending = false
+	return nil
+}
+
+// flush sends the queued report, if any.
+func (s *State) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.pending {
+		return
 	}
+	err := s.report(s.last)
+	if err != nil {
+		Log.Error("queued report failed for server: ", err)
+		if !unreachable(err) {
+			s.pending = false
+		}
+		return
+	}
+	s.pending = false
+}
+
+// Dropped returns the number of queued reports replaced by a later one
+// before they could be sent.
+func (s *State) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *State) report(state pb.ReportReq_State) error {
+	req := &pb.ReportReq{TargetUuid: s.uuid, State: state,
+		ReporteeUuid: s.uuid}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		s.link.opts.dialTimeout)
+	defer cancel()
+	_, err := s.state.ReportState(ctx, req)
 	return err
 }
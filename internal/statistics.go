@@ -4,37 +4,61 @@ import (
 	"context"
 	"errors"
 	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStatsInterval = 10 * time.Second
+	defaultStatsBuffer   = 64
 )
 
 type Statistics struct {
 	uuid       string
-	conn       *grpc.ClientConn
+	interval   time.Duration
+	link       *link
 	statistics pb.StatisticsClient
 	enabled    bool
-}
+	metrics    *metrics
+	queue      []*pb.MetricBatch
+	buffer     int
+	dropped    uint64
+	flushNow   chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
 
-func InitializeStatistics(uuid string, host string, stats *Statistics) error {
-	var opts []grpc.DialOption
-
-	opts = append(opts,
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
-	opts = append(opts, grpc.WithBlock())
+	mu         sync.Mutex
+	registered bool
+	current    bool
+	running    sync.Once
+	shut       sync.Once
+}
 
-	Log.Debug("dialling: ", host)
-	conn, err := grpc.Dial(host, opts...)
+func InitializeStatistics(uuid string, host string, interval uint32, buffer uint32, opts linkOptions, stats *Statistics) error {
+	l, err := dialLink(host, opts)
 	if err != nil {
-		Log.Error("server connect fail: ", err)
 		return err
 	}
 
-	cli := pb.NewStatisticsClient(conn)
-
 	stats.uuid = uuid
-	stats.conn = conn
-	stats.statistics = cli
+	stats.interval = defaultStatsInterval
+	if interval != 0 {
+		stats.interval = time.Duration(interval) * time.Second
+	}
+	stats.buffer = defaultStatsBuffer
+	if buffer != 0 {
+		stats.buffer = int(buffer)
+	}
+	stats.link = l
+	stats.statistics = pb.NewStatisticsClient(l.conn)
 	stats.enabled = true
+	stats.metrics = newMetrics()
+	stats.flushNow = make(chan struct{}, 1)
+	stats.done = make(chan struct{})
+	l.start(stats.reconnected)
 
 	return err
 }
@@ -45,24 +69,195 @@ func ShutDownStatistics(stats *Statistics) error {
 		return nil
 	}
 
-	stats.conn.Close()
-
+	stats.shut.Do(func() {
+		close(stats.done)
+		stats.wg.Wait()
+		stats.link.close()
+	})
 	return nil
 }
 
+// RegisterForStats fails if the daemon is unreachable, unless the link is
+// lazy, then registration happens as soon as the daemon is.
 func (s *Statistics) RegisterForStats() error {
 	if s.enabled == false {
 		return nil
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.registered = true
+	s.running.Do(func() {
+		s.wg.Add(1)
+		go s.run()
+	})
+	err := s.register()
+	if err != nil {
+		Log.Error("registration failed for server")
+		if s.link.opts.lazy {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *Statistics) register() error {
 	req := &pb.RegisterReq{Uuid: s.uuid, Type: pb.RegisterReq_SERVER}
-	ctx := context.TODO()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		s.link.opts.dialTimeout)
+	defer cancel()
 	res, err := s.statistics.RegisterForStats(ctx, req)
 	if err != nil {
-		Log.Error("registration failed for server")
+		return err
 	}
-	if err == nil && res.Enrolled == false {
+	if res.Enrolled == false {
 		Log.Error("registration not enrolled for server")
-		err = errors.New("registration not enrolled")
+		return errors.New("registration not enrolled")
+	}
+	s.current = true
+	return nil
+}
+
+// reconnected registers again, the daemon may have restarted and
+// forgotten the server. A lazy link coming up the first time only
+// registers if that has not happened yet.
+func (s *Statistics) reconnected(again bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.registered || (!again && s.current) {
+		return nil
+	}
+	err := s.register()
+	if err == nil {
+		select {
+		case s.flushNow <- struct{}{}:
+		default:
+		}
 	}
 	return err
 }
+
+// run pushes the metrics recorded since the last push every interval, on
+// reconnect, and once more when statistics is shut down.
+func (s *Statistics) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.push()
+			return
+		case <-ticker.C:
+			s.push()
+		case <-s.flushNow:
+			s.push()
+		}
+	}
+}
+
+// push queues the metrics recorded since the last push and sends the
+// queued batches in order. While the daemon is unreachable the queue
+// holds up to buffer batches, the oldest are dropped beyond that. The
+// queue is sent before it is trimmed, so the batch recorded while the
+// daemon came back does not push an older one out.
+func (s *Statistics) push() {
+	metrics := s.metrics.flush()
+	if len(metrics) != 0 {
+		s.queue = append(s.queue, &pb.MetricBatch{Uuid: s.uuid,
+			Metrics: metrics})
+	}
+	if s.link.ready() {
+		s.sendQueue()
+	}
+	s.trim()
+}
+
+func (s *Statistics) sendQueue() {
+	for len(s.queue) != 0 {
+		err := s.send(s.queue[0])
+		if err != nil {
+			Log.Error("metrics push failed: ", err)
+			if status.Code(err) == codes.NotFound {
+				s.mu.Lock()
+				s.register()
+				s.mu.Unlock()
+			}
+			return
+		}
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+}
+
+func (s *Statistics) trim() {
+	for len(s.queue) > s.buffer {
+		Log.Errorf("statistics unreachable, dropped batch of %d metrics",
+			len(s.queue[0].Metrics))
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		atomic.AddUint64(&s.dropped, 1)
+		s.metrics.counter("serverbox_metric_batches_dropped_total", nil, 1)
+	}
+}
+
+func (s *Statistics) send(batch *pb.MetricBatch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	stream, err := s.statistics.PushMetrics(ctx)
+	if err == nil {
+		err = stream.Send(batch)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if res.Rejected != 0 {
+		Log.Errorf("statistics rejected %d of %d metrics", res.Rejected,
+			len(batch.Metrics))
+	}
+	return nil
+}
+
+// Dropped returns the number of metric batches dropped because the daemon
+// was unreachable for longer than the buffer lasts.
+func (s *Statistics) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Statistics) Counter(name string, labels map[string]string, delta float64) {
+	if s.enabled {
+		s.metrics.counter(name, labels, delta)
+	}
+}
+
+func (s *Statistics) Gauge(name string, labels map[string]string, value float64) {
+	if s.enabled {
+		s.metrics.gauge(name, labels, value)
+	}
+}
+
+func (s *Statistics) AddGauge(name string, labels map[string]string, delta float64) {
+	if s.enabled {
+		s.metrics.addGauge(name, labels, delta)
+	}
+}
+
+// Observe records value in a histogram, with defaultBounds when bounds is
+// nil. The bounds of a histogram are fixed by its first observation.
+func (s *Statistics) Observe(name string, labels map[string]string, bounds []float64, value float64) {
+	if !s.enabled {
+		return
+	}
+	if bounds == nil {
+		bounds = defaultBounds
+	}
+	s.metrics.observe(name, labels, bounds, value)
+}
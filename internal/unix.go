@@ -0,0 +1,162 @@
+package serverbox
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixSocket is where a server binds instead of an ip and port, with the
+// mode and owner the socket file is given.
+type unixSocket struct {
+	path  string
+	mode  os.FileMode
+	owner string
+}
+
+func newUnixSocket(path string, mode string, owner string) (*unixSocket, error) {
+	u := &unixSocket{path: path, owner: owner}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, errors.New("invalid unix socket mode " + mode)
+		}
+		u.mode = os.FileMode(m)
+	}
+	return u, nil
+}
+
+// removeStale removes a socket file left behind by a server that is gone.
+// A socket something still answers on, or a file that is not a socket,
+// is left alone.
+func (u *unixSocket) removeStale(network string) error {
+	fi, err := os.Lstat(u.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.New(u.path + " exists and is not a socket")
+	}
+	conn, err := net.DialTimeout(network, u.path, time.Second)
+	if err == nil {
+		conn.Close()
+		return errors.New(u.path + " is in use")
+	}
+	Log.Info("removing stale socket ", u.path)
+	return os.Remove(u.path)
+}
+
+func lookupId(name string, lookup func(string) (string, error)) (int, error) {
+	id, err := strconv.Atoi(name)
+	if err == nil {
+		return id, nil
+	}
+	s, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// setOwner takes an owner of user, user:group or :group, by name or id.
+func (u *unixSocket) setOwner() error {
+	owner, group := u.owner, ""
+	if i := strings.Index(u.owner, ":"); i >= 0 {
+		owner, group = u.owner[:i], u.owner[i+1:]
+	}
+	uid, gid := -1, -1
+	var err error
+	if owner != "" {
+		uid, err = lookupId(owner, func(name string) (string, error) {
+			usr, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return usr.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		gid, err = lookupId(group, func(name string) (string, error) {
+			grp, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return grp.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chown(u.path, uid, gid)
+}
+
+func (u *unixSocket) setPermissions() error {
+	if u.mode != 0 {
+		err := os.Chmod(u.path, u.mode)
+		if err != nil {
+			return err
+		}
+	}
+	if u.owner != "" {
+		return u.setOwner()
+	}
+	return nil
+}
+
+func (u *unixSocket) listen() (net.Listener, error) {
+	err := u.removeStale("unix")
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", u.path)
+	if err != nil {
+		return nil, err
+	}
+	err = u.setPermissions()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// unlinkPacketConn removes the socket file on close, as a unix listener
+// does.
+type unlinkPacketConn struct {
+	net.PacketConn
+	path string
+}
+
+func (c *unlinkPacketConn) Close() error {
+	err := c.PacketConn.Close()
+	os.Remove(c.path)
+	return err
+}
+
+func (u *unixSocket) listenPacket() (net.PacketConn, error) {
+	err := u.removeStale("unixgram")
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("unixgram", u.path)
+	if err != nil {
+		return nil, err
+	}
+	err = u.setPermissions()
+	if err != nil {
+		pc.Close()
+		os.Remove(u.path)
+		return nil, err
+	}
+	return &unlinkPacketConn{PacketConn: pc, path: u.path}, nil
+}
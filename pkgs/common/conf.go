@@ -6,7 +6,14 @@ import (
 )
 
 func ProcessConfFile(confFile string, confObj interface{}) error {
-	_, err := toml.DecodeFile(confFile, confObj)
+	_, err := DecodeConfFile(confFile, confObj)
+	return err
+}
+
+// DecodeConfFile also returns the metadata needed to decode the
+// toml.Primitive fields of confObj later on.
+func DecodeConfFile(confFile string, confObj interface{}) (toml.MetaData, error) {
+	md, err := toml.DecodeFile(confFile, confObj)
 	if err != nil {
 		pe, ok := err.(toml.ParseError)
 		if ok {
@@ -14,5 +21,5 @@ func ProcessConfFile(confFile string, confObj interface{}) error {
 		}
 	}
 	fmt.Println("configuration read from file ", confObj)
-	return err
+	return md, err
 }
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+)
+
+// TlsConf is the tls section of the links between the servers and the
+// state and statistics daemons. A daemon with a ca verifies client
+// certificates against it, a client with a cert and key presents them.
+// Match_name lets a daemon accept a certificate naming only the name part
+// of a name@address uuid.
+type TlsConf struct {
+	Enabled     bool
+	Ca          string
+	Cert        string
+	Key         string
+	Server_name string
+	Match_name  bool
+}
+
+func loadCa(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates in " + file)
+	}
+	return pool, nil
+}
+
+func ServerTlsConfig(conf TlsConf) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.Ca != "" {
+		config.ClientCAs, err = loadCa(conf.Ca)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTlsConfig verifies the daemon against the system roots unless a
+// ca is given.
+func ClientTlsConfig(conf TlsConf) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: conf.Server_name,
+		MinVersion: tls.VersionTLS12,
+	}
+	var err error
+	if conf.Ca != "" {
+		config.RootCAs, err = loadCa(conf.Ca)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// verifiedCert is the verified client certificate of the peer, if any.
+func verifiedCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// Verified tells whether the peer presented a verified client certificate.
+func Verified(ctx context.Context) bool {
+	return verifiedCert(ctx) != nil
+}
+
+// CheckIdentity makes sure a verified client certificate names the uuid
+// the client acts for, by its common name or one of its DNS or URI
+// alternative names. With matchName, the name alone is enough for a uuid
+// of name@address. Without a verified client certificate there is nothing
+// to check.
+func CheckIdentity(ctx context.Context, uuid string, matchName bool) error {
+	cert := verifiedCert(ctx)
+	if cert == nil {
+		return nil
+	}
+
+	name := uuid
+	if i := strings.Index(uuid, "@"); i >= 0 && matchName {
+		name = uuid[:i]
+	}
+	ids := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	for _, id := range ids {
+		if id != "" && (id == uuid || id == name) {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied,
+		"certificate of %s does not name %s", cert.Subject.CommonName,
+		uuid)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	sb "github.com/ramdrjn/serverbox"
 	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"io"
+	"net"
 )
 
+type echo struct{}
+
+func (echo) ServeConn(ctx context.Context, conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+func (echo) ServePacket(ctx context.Context, conn net.PacketConn, addr net.Addr, packet []byte) {
+	conn.WriteTo(packet, addr)
+}
+
 type msg struct {
 	reply string
 }
@@ -32,6 +45,18 @@ func main() {
 	if err != nil {
 		ctx.Log.Error(err)
 	}
+	err = sb.AttachRouter(r, "local", ctx)
+	if err != nil {
+		ctx.Log.Error(err)
+	}
+	err = sb.AttachConnHandler(echo{}, "echo", ctx)
+	if err != nil {
+		ctx.Log.Error(err)
+	}
+	err = sb.AttachPacketHandler(echo{}, "echo_udp", ctx)
+	if err != nil {
+		ctx.Log.Error(err)
+	}
 	err = sb.Run(ctx)
 	if err != nil {
 		ctx.Log.Error(err)
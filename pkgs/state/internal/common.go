@@ -5,13 +5,47 @@ import (
 )
 
 type StateContext struct {
-	Log  common.Logger
-	Conf stateConf
+	Log    common.Logger
+	Conf   stateConf
+	server *stateServer
 }
 
 var Log common.Logger
 
 type stateConf struct {
-	Host string
-	Port uint32
+	Host                 string
+	Port                 uint32
+	Watch_buffer         int
+	Lease_check_interval uint32
+	History_size         int
+	Store                storeConf
+	Webhooks             []webhookConf
+	Webhook_dead_letter  string
+	Reporters            []string
+	Tls                  common.TlsConf
+	Statistics           statisticsConf
+}
+
+type statisticsConf struct {
+	Enabled  bool
+	Host     string
+	Port     uint32
+	Interval uint32
+	Tls      common.TlsConf
+}
+
+type storeConf struct {
+	Type              string
+	Dir               string
+	Snapshot_interval uint32
+}
+
+type webhookConf struct {
+	Url         string
+	Secret      string
+	States      []string
+	Uuid        string
+	Max_retries int
+	Timeout     uint32
+	Queue       int
 }
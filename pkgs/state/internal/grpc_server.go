@@ -2,7 +2,9 @@ package state
 
 import (
 	"fmt"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"net"
 )
 
@@ -18,9 +20,24 @@ func InitializeGrpcServer(stc *StateContext) error {
 		return err
 	}
 
-	grpcServer = grpc.NewServer()
+	var opts []grpc.ServerOption
+	if stc.Conf.Tls.Enabled {
+		config, err := common.ServerTlsConfig(stc.Conf.Tls)
+		if err != nil {
+			stc.Log.Error("tls configuration failed: ", err)
+			lis.Close()
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	grpcServer = grpc.NewServer(opts...)
 
-	RegisterService_SB_State(grpcServer)
+	err = RegisterService_SB_State(grpcServer, stc)
+	if err != nil {
+		stc.Log.Error("state service failed: ", err)
+		lis.Close()
+		return err
+	}
 
 	stc.Log.Infof("starting grpc server on %s", host)
 	grpcServer.Serve(lis)
@@ -32,6 +49,7 @@ func ShutDownGrpcServer(stc *StateContext) error {
 	stc.Log.Info("stopping grpc server")
 
 	grpcServer.GracefulStop()
+	stc.server.close()
 
 	return nil
 }
@@ -40,6 +58,7 @@ func AbortGrpcServer(stc *StateContext) error {
 	stc.Log.Info("aborting grpc server")
 
 	grpcServer.Stop()
+	stc.server.close()
 
 	return nil
 }
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"time"
+)
+
+const defaultHistorySize = 64
+
+// history is a fixed size ring of the latest transitions of one entity,
+// oldest first.
+type history struct {
+	transitions []transition
+	start       int
+	count       int
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	return &history{transitions: make([]transition, size)}
+}
+
+func (h *history) add(t transition) {
+	size := len(h.transitions)
+	if h.count < size {
+		h.transitions[(h.start+h.count)%size] = t
+		h.count++
+		return
+	}
+	h.transitions[h.start] = t
+	h.start = (h.start + 1) % size
+}
+
+// since returns the newest limit transitions that happened at or after
+// from, oldest first. A limit of zero means no limit.
+func (h *history) since(from time.Time, limit int) []transition {
+	var transitions []transition
+	size := len(h.transitions)
+	for i := 0; i < h.count; i++ {
+		t := h.transitions[(h.start+i)%size]
+		if t.entity.lastChange.Before(from) {
+			continue
+		}
+		transitions = append(transitions, t)
+	}
+	if limit != 0 && len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+	return transitions
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Lifecycle of a registered entity:
+//
+//	REGISTERED -> MAINTANENCE -> UP <-> DRAINING -> DOWN
+//
+// with UP allowed back to MAINTANENCE, any state allowed to go DOWN, and a
+// DOWN entity brought back through MAINTANENCE. REGISTERED is only entered
+// through RegisterForState and UNKNOWN only when the daemon expires a
+// lease, after which the entity may report any state other than those two.
+var legalTransitions = map[pb.ReportReq_State][]pb.ReportReq_State{
+	pb.ReportReq_REGISTERED: {pb.ReportReq_MAINTANENCE,
+		pb.ReportReq_DOWN},
+	pb.ReportReq_MAINTANENCE: {pb.ReportReq_UP, pb.ReportReq_DOWN},
+	pb.ReportReq_UP: {pb.ReportReq_DRAINING, pb.ReportReq_MAINTANENCE,
+		pb.ReportReq_DOWN},
+	pb.ReportReq_DRAINING: {pb.ReportReq_UP, pb.ReportReq_DOWN},
+	pb.ReportReq_DOWN:     {pb.ReportReq_MAINTANENCE},
+	pb.ReportReq_UNKNOWN: {pb.ReportReq_MAINTANENCE, pb.ReportReq_UP,
+		pb.ReportReq_DRAINING, pb.ReportReq_DOWN},
+}
+
+// checkTransition allows reporting the current state again, which only
+// refreshes the reportee and reason.
+func checkTransition(from pb.ReportReq_State, to pb.ReportReq_State) error {
+	if from == to {
+		return nil
+	}
+	for _, next := range legalTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return status.Errorf(codes.FailedPrecondition,
+		"illegal transition to %s, current state is %s", to, from)
+}
@@ -0,0 +1,288 @@
+package state
+
+import (
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"sync"
+	"time"
+)
+
+type entity struct {
+	uuid         string
+	eType        pb.RegisterReq_Type
+	state        pb.ReportReq_State
+	registeredAt time.Time
+	lastChange   time.Time
+	reporteeUuid string
+	reason       string
+	// ttl of zero means the entity holds no lease and never expires.
+	ttl           time.Duration
+	lastHeartbeat time.Time
+}
+
+type transition struct {
+	previous pb.ReportReq_State
+	entity   entity
+}
+
+type registry struct {
+	mu       sync.RWMutex
+	entities map[string]*entity
+	now      func() time.Time
+	store    store
+	// histories outlive re-registration so restarts stay visible, but
+	// they are kept in memory only.
+	histories   map[string]*history
+	historySize int
+	// notify is called with the registry locked, so transitions are
+	// delivered in the order they were applied.
+	notify func(transition)
+}
+
+func newRegistry() *registry {
+	return &registry{
+		entities:  make(map[string]*entity),
+		now:       time.Now,
+		store:     memoryStore{},
+		histories: make(map[string]*history),
+	}
+}
+
+// restore fills the registry from the records of its store. Leases are
+// renewed from now so entities get a full ttl to heartbeat the restarted
+// daemon.
+func (r *registry) restore(recs []record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for _, rec := range recs {
+		e := rec.toEntity()
+		e.lastHeartbeat = now
+		r.entities[e.uuid] = e
+	}
+}
+
+func (r *registry) persist(e *entity) error {
+	err := r.store.put(toRecord(e))
+	if err != nil {
+		Log.Error("storing state failed: ", err)
+		return status.Errorf(codes.Unavailable,
+			"storing state of %s failed", e.uuid)
+	}
+	return nil
+}
+
+// register adds the uuid to the registry. A uuid that is already known is
+// reset, as that means the entity behind it has been restarted, a new one
+// has no previous state and so records no transition.
+func (r *registry) register(uuid string, eType pb.RegisterReq_Type, ttl time.Duration) (entity, error) {
+	if uuid == "" {
+		return entity{}, status.Error(codes.InvalidArgument,
+			"uuid is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	old, known := r.entities[uuid]
+	e := &entity{
+		uuid:          uuid,
+		eType:         eType,
+		state:         pb.ReportReq_REGISTERED,
+		registeredAt:  now,
+		lastChange:    now,
+		reason:        "registered",
+		ttl:           ttl,
+		lastHeartbeat: now,
+	}
+	err := r.persist(e)
+	if err != nil {
+		return entity{}, err
+	}
+	r.entities[uuid] = e
+	if known {
+		r.changed(old.state, e)
+	}
+	return *e, nil
+}
+
+func (r *registry) report(req *pb.ReportReq) (entity, error) {
+	if req.TargetUuid == "" {
+		return entity{}, status.Error(codes.InvalidArgument,
+			"target uuid is required")
+	}
+	if _, ok := pb.ReportReq_State_name[int32(req.State)]; !ok {
+		return entity{}, status.Errorf(codes.InvalidArgument,
+			"invalid state %d", req.State)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entities[req.TargetUuid]
+	if !ok {
+		return entity{}, status.Errorf(codes.NotFound,
+			"%s is not registered", req.TargetUuid)
+	}
+
+	previous := e.state
+	err := checkTransition(previous, req.State)
+	if err != nil {
+		return *e, err
+	}
+
+	updated := *e
+	if previous != req.State {
+		updated.lastChange = r.now()
+	}
+	if req.ReporteeUuid == e.uuid {
+		updated.lastHeartbeat = r.now()
+	}
+	updated.state = req.State
+	updated.reporteeUuid = req.ReporteeUuid
+	updated.reason = req.Reason
+	err = r.persist(&updated)
+	if err != nil {
+		return *e, err
+	}
+	*e = updated
+	if previous != req.State {
+		r.changed(previous, e)
+	}
+	return *e, nil
+}
+
+// heartbeat renews the lease of uuid. A lease that has already expired is
+// not renewed, the entity has to register again and report its state.
+func (r *registry) heartbeat(uuid string) (entity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entities[uuid]
+	if !ok {
+		return entity{}, status.Errorf(codes.NotFound,
+			"%s is not registered", uuid)
+	}
+	if e.ttl != 0 && e.state == pb.ReportReq_UNKNOWN {
+		return *e, status.Errorf(codes.FailedPrecondition,
+			"lease of %s expired, register again", uuid)
+	}
+	e.lastHeartbeat = r.now()
+	return *e, nil
+}
+
+// expire moves every entity whose lease ran out to UNKNOWN and returns
+// how many were expired. The expiry is applied even if it cannot be stored,
+// it is repeated by the next daemon after a restart anyway.
+func (r *registry) expire() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	expired := 0
+	for _, e := range r.entities {
+		if e.ttl == 0 || e.state == pb.ReportReq_UNKNOWN ||
+			e.state == pb.ReportReq_DOWN {
+			continue
+		}
+		if now.Sub(e.lastHeartbeat) <= e.ttl {
+			continue
+		}
+		previous := e.state
+		e.state = pb.ReportReq_UNKNOWN
+		e.lastChange = now
+		e.reporteeUuid = ""
+		e.reason = "lease expired"
+		r.persist(e)
+		r.changed(previous, e)
+		expired++
+	}
+	return expired
+}
+
+func (r *registry) changed(previous pb.ReportReq_State, e *entity) {
+	t := transition{previous: previous, entity: *e}
+
+	h, ok := r.histories[e.uuid]
+	if !ok {
+		h = newHistory(r.historySize)
+		r.histories[e.uuid] = h
+	}
+	h.add(t)
+
+	if r.notify != nil {
+		r.notify(t)
+	}
+}
+
+func (r *registry) history(uuid string, since time.Time, limit int) ([]transition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.entities[uuid]; !ok {
+		return nil, status.Errorf(codes.NotFound,
+			"%s is not registered", uuid)
+	}
+	h, ok := r.histories[uuid]
+	if !ok {
+		return nil, nil
+	}
+	return h.since(since, limit), nil
+}
+
+func (r *registry) lookup(uuid string) (entity, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.entities[uuid]
+	if !ok {
+		return entity{}, false
+	}
+	return *e, true
+}
+
+// list returns a copy of every entity accepted by filter, ordered by uuid.
+func (r *registry) list(filter func(*entity) bool) []entity {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.copyEntities(filter)
+}
+
+// snapshot hands fn a copy of every entity. No transition is notified nor
+// stored while fn runs.
+func (r *registry) snapshot(fn func([]entity)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fn(r.copyEntities(nil))
+}
+
+func (r *registry) copyEntities(filter func(*entity) bool) []entity {
+	entities := make([]entity, 0, len(r.entities))
+	for _, e := range r.entities {
+		if filter == nil || filter(e) {
+			entities = append(entities, *e)
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].uuid < entities[j].uuid
+	})
+	return entities
+}
+
+func (r *registry) compact() error {
+	var err error
+	r.snapshot(func(entities []entity) {
+		recs := make([]record, 0, len(entities))
+		for i := range entities {
+			recs = append(recs, toRecord(&entities[i]))
+		}
+		err = r.store.snapshot(recs)
+	})
+	return err
+}
@@ -2,24 +2,380 @@ package state
 
 import (
 	"context"
+	"fmt"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaseCheckInterval = time.Second
+	defaultSnapshotInterval   = time.Minute
 )
 
 type stateServer struct {
 	pb.UnimplementedStateServer
+	registry *registry
+	broker   *broker
+	notifier *notifier
+	bridge   *statsBridge
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	//identity checks
+	matchName bool
+	reporters []string
+}
+
+func newStateServer(conf stateConf) (*stateServer, error) {
+	for _, r := range conf.Reporters {
+		_, err := matchUuid(r, "")
+		if err != nil {
+			return nil, fmt.Errorf("reporter pattern %q: %w", r, err)
+		}
+	}
+	st, err := openStore(conf.Store)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := st.load()
+	if err != nil {
+		st.close()
+		return nil, err
+	}
+	n, err := newNotifier(conf.Webhooks, conf.Webhook_dead_letter)
+	if err != nil {
+		st.close()
+		return nil, err
+	}
+
+	s := &stateServer{
+		registry:  newRegistry(),
+		broker:    newBroker(conf.Watch_buffer),
+		notifier:  n,
+		done:      make(chan struct{}),
+		matchName: conf.Tls.Match_name,
+		reporters: conf.Reporters,
+	}
+	s.registry.store = st
+	s.registry.historySize = conf.History_size
+	s.registry.restore(recs)
+	s.registry.notify = s.transitioned
+	s.notifier.start()
+	if conf.Statistics.Enabled {
+		s.bridge, err = newStatsBridge(conf)
+		if err != nil {
+			st.close()
+			s.notifier.close()
+			return nil, err
+		}
+		s.registry.snapshot(s.bridge.start)
+	}
+	if len(recs) != 0 {
+		Log.Infof("restored %d entities from %s store", len(recs),
+			conf.Store.Type)
+	}
+
+	interval := defaultLeaseCheckInterval
+	if conf.Lease_check_interval != 0 {
+		interval = time.Duration(conf.Lease_check_interval) * time.Second
+	}
+	s.wg.Add(2)
+	go s.expireLeases(interval)
+
+	interval = defaultSnapshotInterval
+	if conf.Store.Snapshot_interval != 0 {
+		interval = time.Duration(conf.Store.Snapshot_interval) *
+			time.Second
+	}
+	go s.compactStore(interval)
+	return s, nil
+}
+
+func (s *stateServer) close() {
+	close(s.done)
+	s.wg.Wait()
+
+	err := s.registry.compact()
+	if err != nil {
+		Log.Error("final snapshot failed: ", err)
+	}
+	s.registry.store.close()
+	s.notifier.close()
+	if s.bridge != nil {
+		s.bridge.close()
+	}
+}
+
+func (s *stateServer) transitioned(t transition) {
+	s.broker.publish(t)
+	s.notifier.notify(t)
+	if s.bridge != nil {
+		s.bridge.transition(t)
+	}
 }
 
-func RegisterService_SB_State(grpcServer grpc.ServiceRegistrar) {
-	pb.RegisterStateServer(grpcServer, &stateServer{})
+func RegisterService_SB_State(grpcServer grpc.ServiceRegistrar, stc *StateContext) (err error) {
+	stc.server, err = newStateServer(stc.Conf)
+	if err != nil {
+		return err
+	}
+	pb.RegisterStateServer(grpcServer, stc.server)
+	return nil
 }
 
-func (*stateServer) RegisterForState(ctx context.Context, req *pb.RegisterReq) (res *pb.RegisterRes, err error) {
+func (s *stateServer) expireLeases(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			n := s.registry.expire()
+			if n != 0 {
+				Log.Infof("%d leases expired", n)
+			}
+		}
+	}
+}
+
+func (s *stateServer) compactStore(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			err := s.registry.compact()
+			if err != nil {
+				Log.Error("snapshot failed: ", err)
+			}
+		}
+	}
+}
+
+func (s *stateServer) RegisterForState(ctx context.Context, req *pb.RegisterReq) (res *pb.RegisterRes, err error) {
+	err = common.CheckIdentity(ctx, req.Uuid, s.matchName)
+	if err != nil {
+		Log.Error("registration refused: ", err)
+		return nil, err
+	}
+	ttl := time.Duration(req.Ttl) * time.Second
+	e, err := s.registry.register(req.Uuid, req.Type, ttl)
+	if err != nil {
+		Log.Error("registration failed: ", err)
+		return nil, err
+	}
+	Log.Infof("registered %s of type %s with ttl %s", e.uuid, e.eType,
+		e.ttl)
+
 	r := &pb.RegisterRes{}
 	return r, nil
 }
 
-func (*stateServer) ReportState(ctx context.Context, req *pb.ReportReq) (res *pb.ReportRes, err error) {
+// ReportState checks the identity of the reportee. A verified client
+// reports on itself only, unless it is one of the configured reporters.
+func (s *stateServer) ReportState(ctx context.Context, req *pb.ReportReq) (res *pb.ReportRes, err error) {
+	reportee := req.ReporteeUuid
+	if reportee == "" {
+		reportee = req.TargetUuid
+	}
+	err = common.CheckIdentity(ctx, reportee, s.matchName)
+	if err == nil && reportee != req.TargetUuid && common.Verified(ctx) &&
+		!s.reporter(reportee) {
+		err = status.Errorf(codes.PermissionDenied,
+			"%s may not report on %s", reportee, req.TargetUuid)
+	}
+	if err != nil {
+		Log.Error("report refused: ", err)
+		return nil, err
+	}
+	e, err := s.registry.report(req)
+	if err != nil {
+		Log.Error("report failed: ", err)
+		return nil, err
+	}
+	Log.Infof("%s reported %s by %s: %s", e.uuid, e.state,
+		e.reporteeUuid, e.reason)
+
 	r := &pb.ReportRes{}
 	return r, nil
 }
+
+func (s *stateServer) reporter(uuid string) bool {
+	for _, r := range s.reporters {
+		ok, _ := matchUuid(r, uuid)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stateServer) Heartbeat(ctx context.Context, req *pb.HeartbeatReq) (res *pb.HeartbeatRes, err error) {
+	err = common.CheckIdentity(ctx, req.Uuid, s.matchName)
+	if err != nil {
+		Log.Error("heartbeat refused: ", err)
+		return nil, err
+	}
+	_, err = s.registry.heartbeat(req.Uuid)
+	if err != nil {
+		Log.Debug("heartbeat failed: ", err)
+		return nil, err
+	}
+
+	r := &pb.HeartbeatRes{}
+	return r, nil
+}
+
+func toStateInfo(e entity) *pb.StateInfo {
+	return &pb.StateInfo{
+		Uuid:          e.uuid,
+		Type:          e.eType,
+		State:         e.state,
+		Reason:        e.reason,
+		ReporteeUuid:  e.reporteeUuid,
+		RegisteredAt:  timestamppb.New(e.registeredAt),
+		LastChange:    timestamppb.New(e.lastChange),
+		Ttl:           uint32(e.ttl / time.Second),
+		LastHeartbeat: timestamppb.New(e.lastHeartbeat),
+	}
+}
+
+func (s *stateServer) GetState(ctx context.Context, req *pb.GetStateReq) (res *pb.GetStateRes, err error) {
+	e, ok := s.registry.lookup(req.Uuid)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound,
+			"%s is not registered", req.Uuid)
+	}
+
+	r := &pb.GetStateRes{Info: toStateInfo(e)}
+	return r, nil
+}
+
+func (s *stateServer) ListStates(ctx context.Context, req *pb.ListStatesReq) (res *pb.ListStatesRes, err error) {
+	states := make(map[pb.ReportReq_State]bool)
+	for _, st := range req.States {
+		states[st] = true
+	}
+
+	filter := func(e *entity) bool {
+		if len(states) != 0 && !states[e.state] {
+			return false
+		}
+		return strings.HasPrefix(e.uuid, req.UuidPrefix)
+	}
+
+	r := &pb.ListStatesRes{}
+	for _, e := range s.registry.list(filter) {
+		r.Infos = append(r.Infos, toStateInfo(e))
+	}
+	return r, nil
+}
+
+func (s *stateServer) GetHistory(ctx context.Context, req *pb.GetHistoryReq) (res *pb.GetHistoryRes, err error) {
+	var since time.Time
+	if req.Since != nil {
+		since = req.Since.AsTime()
+	}
+	transitions, err := s.registry.history(req.Uuid, since, int(req.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &pb.GetHistoryRes{}
+	for _, t := range transitions {
+		r.Transitions = append(r.Transitions, &pb.Transition{
+			PreviousState: t.previous,
+			State:         t.entity.state,
+			ReporteeUuid:  t.entity.reporteeUuid,
+			Reason:        t.entity.reason,
+			Timestamp:     timestamppb.New(t.entity.lastChange),
+		})
+	}
+	return r, nil
+}
+
+func (s *stateServer) WatchState(req *pb.WatchReq, stream pb.State_WatchStateServer) error {
+	w, err := s.watch(req, stream)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		s.broker.unsubscribe(w)
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case t := <-w.events:
+			err = stream.Send(&pb.StateEvent{
+				Type:          pb.StateEvent_TRANSITION,
+				Info:          toStateInfo(t.entity),
+				PreviousState: t.previous,
+			})
+		case <-w.resync:
+			Log.Info("watcher fell behind, sending resync")
+			s.broker.unsubscribe(w)
+			err = stream.Send(&pb.StateEvent{
+				Type: pb.StateEvent_RESYNC,
+			})
+			if err == nil {
+				w, err = s.watch(req, stream)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watch subscribes to transitions and sends the snapshot they follow on
+// from, terminated by SNAPSHOT_DONE.
+func (s *stateServer) watch(req *pb.WatchReq, stream pb.State_WatchStateServer) (*watcher, error) {
+	var w *watcher
+	var snapshot []entity
+	s.registry.snapshot(func(entities []entity) {
+		w = s.broker.subscribe(req.Uuids)
+		snapshot = entities
+	})
+
+	var err error
+	for _, e := range snapshot {
+		if !w.wants(e.uuid) {
+			continue
+		}
+		err = stream.Send(&pb.StateEvent{
+			Type: pb.StateEvent_SNAPSHOT,
+			Info: toStateInfo(e),
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = stream.Send(&pb.StateEvent{
+			Type: pb.StateEvent_SNAPSHOT_DONE,
+		})
+	}
+	if err != nil {
+		s.broker.unsubscribe(w)
+		return nil, err
+	}
+	return w, nil
+}
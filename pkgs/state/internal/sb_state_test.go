@@ -2,15 +2,701 @@ package state
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-var s = &stateServer{}
+var s *stateServer
+
+func init() {
+	var err error
+	Log = common.InitializeLogger("state", common.DebugLevel)
+	s, err = newStateServer(stateConf{})
+	if err != nil {
+		panic(err)
+	}
+}
 
 func TestRegisterForState(t *testing.T) {
 	req := &pb.RegisterReq{}
 	req.Uuid = "test"
 	req.Type = pb.RegisterReq_SERVER
-	s.RegisterForState(context.TODO(), req)
+	_, err := s.RegisterForState(context.TODO(), req)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReportState(t *testing.T) {
+	req := &pb.ReportReq{TargetUuid: "test",
+		State: pb.ReportReq_MAINTANENCE}
+	_, err := s.ReportState(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = &pb.ReportReq{TargetUuid: "test", State: pb.ReportReq_UP,
+		ReporteeUuid: "test", Reason: "started"}
+	_, err = s.ReportState(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := s.registry.lookup("test")
+	if !ok {
+		t.Fatal("test not found in registry")
+	}
+	if e.state != pb.ReportReq_UP || e.reason != "started" ||
+		e.reporteeUuid != "test" {
+		t.Errorf("unexpected entity %+v", e)
+	}
+}
+
+func TestReportStateUnregistered(t *testing.T) {
+	req := &pb.ReportReq{TargetUuid: "unknown", State: pb.ReportReq_UP}
+	_, err := s.ReportState(context.TODO(), req)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestReportStateIllegalTransition(t *testing.T) {
+	_, err := s.RegisterForState(context.TODO(),
+		&pb.RegisterReq{Uuid: "lifecycle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &pb.ReportReq{TargetUuid: "lifecycle", State: pb.ReportReq_UP}
+	_, err = s.ReportState(context.TODO(), req)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+
+	for _, st := range []pb.ReportReq_State{pb.ReportReq_MAINTANENCE,
+		pb.ReportReq_UP, pb.ReportReq_DRAINING, pb.ReportReq_UP,
+		pb.ReportReq_DRAINING, pb.ReportReq_DOWN} {
+		req = &pb.ReportReq{TargetUuid: "lifecycle", State: st}
+		_, err = s.ReportState(context.TODO(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req = &pb.ReportReq{TargetUuid: "lifecycle", State: pb.ReportReq_UP}
+	_, err = s.ReportState(context.TODO(), req)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestGetState(t *testing.T) {
+	res, err := s.GetState(context.TODO(), &pb.GetStateReq{Uuid: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Info.Uuid != "test" || res.Info.LastChange == nil {
+		t.Errorf("unexpected state info %v", res.Info)
+	}
+
+	_, err = s.GetState(context.TODO(), &pb.GetStateReq{Uuid: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestListStates(t *testing.T) {
+	for _, uuid := range []string{"web@a:1", "web@b:1", "api@a:1"} {
+		req := &pb.RegisterReq{Uuid: uuid, Type: pb.RegisterReq_SERVER}
+		_, err := s.RegisterForState(context.TODO(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := &pb.ReportReq{TargetUuid: "web@b:1", State: pb.ReportReq_DOWN}
+	_, err := s.ReportState(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.ListStates(context.TODO(),
+		&pb.ListStatesReq{UuidPrefix: "web@"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Infos) != 2 || res.Infos[0].Uuid != "web@a:1" {
+		t.Errorf("unexpected prefix listing %v", res.Infos)
+	}
+
+	res, err = s.ListStates(context.TODO(), &pb.ListStatesReq{
+		States: []pb.ReportReq_State{pb.ReportReq_DOWN}, UuidPrefix: "web@"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Infos) != 1 || res.Infos[0].Uuid != "web@b:1" {
+		t.Errorf("unexpected state listing %v", res.Infos)
+	}
+}
+
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.StateEvent
+}
+
+func (w *watchStream) Context() context.Context {
+	return w.ctx
+}
+
+func (w *watchStream) Send(ev *pb.StateEvent) error {
+	w.events <- ev
+	return nil
+}
+
+func TestWatchState(t *testing.T) {
+	ws, err := newStateServer(stateConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ws.RegisterForState(context.TODO(),
+		&pb.RegisterReq{Uuid: "watched"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchStream{ctx: ctx, events: make(chan *pb.StateEvent, 8)}
+	done := make(chan error)
+	go func() {
+		done <- ws.WatchState(&pb.WatchReq{Uuids: []string{"watched"}},
+			stream)
+	}()
+
+	ev := <-stream.events
+	if ev.Type != pb.StateEvent_SNAPSHOT || ev.Info.Uuid != "watched" {
+		t.Errorf("expected snapshot of watched, got %v", ev)
+	}
+	ev = <-stream.events
+	if ev.Type != pb.StateEvent_SNAPSHOT_DONE {
+		t.Errorf("expected end of snapshot, got %v", ev)
+	}
+
+	_, err = ws.ReportState(context.TODO(), &pb.ReportReq{
+		TargetUuid: "watched", State: pb.ReportReq_MAINTANENCE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = <-stream.events
+	if ev.Type != pb.StateEvent_TRANSITION ||
+		ev.PreviousState != pb.ReportReq_REGISTERED ||
+		ev.Info.State != pb.ReportReq_MAINTANENCE {
+		t.Errorf("unexpected transition %v", ev)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	b := newBroker(1)
+	w := b.subscribe(nil)
+	b.publish(transition{entity: entity{uuid: "a"}})
+	b.publish(transition{entity: entity{uuid: "a"}})
+
+	select {
+	case <-w.resync:
+	default:
+		t.Error("expected resync after overflow")
+	}
+	if len(w.events) != 1 {
+		t.Errorf("expected 1 buffered event, got %d", len(w.events))
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	now := time.Now()
+	r := newRegistry()
+	r.now = func() time.Time { return now }
+
+	_, err := r.register("leased", pb.RegisterReq_SERVER, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.report(&pb.ReportReq{TargetUuid: "leased",
+		State: pb.ReportReq_MAINTANENCE, ReporteeUuid: "leased"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(8 * time.Second)
+	_, err = r.heartbeat("leased")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(8 * time.Second)
+	if n := r.expire(); n != 0 {
+		t.Errorf("expected no expiry within ttl, got %d", n)
+	}
+
+	now = now.Add(3 * time.Second)
+	if n := r.expire(); n != 1 {
+		t.Errorf("expected 1 expiry, got %d", n)
+	}
+	e, _ := r.lookup("leased")
+	if e.state != pb.ReportReq_UNKNOWN || e.reason != "lease expired" {
+		t.Errorf("unexpected entity after expiry %+v", e)
+	}
+
+	_, err = r.report(&pb.ReportReq{TargetUuid: "leased",
+		State: pb.ReportReq_UP, ReporteeUuid: "leased"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHeartbeatAfterExpiry(t *testing.T) {
+	now := time.Now()
+	es, err := newStateServer(stateConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.close()
+	es.registry.now = func() time.Time { return now }
+
+	register := func() {
+		_, err := es.RegisterForState(context.TODO(),
+			&pb.RegisterReq{Uuid: "stalled", Ttl: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, st := range []pb.ReportReq_State{
+			pb.ReportReq_MAINTANENCE, pb.ReportReq_UP} {
+			_, err = es.ReportState(context.TODO(), &pb.ReportReq{
+				TargetUuid: "stalled", State: st,
+				ReporteeUuid: "stalled"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	register()
+
+	now = now.Add(11 * time.Second)
+	es.registry.expire()
+	_, err = es.Heartbeat(context.TODO(), &pb.HeartbeatReq{Uuid: "stalled"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+
+	//what the client does on FailedPrecondition
+	register()
+	_, err = es.Heartbeat(context.TODO(), &pb.HeartbeatReq{Uuid: "stalled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := es.registry.lookup("stalled")
+	if e.state != pb.ReportReq_UP {
+		t.Errorf("expected UP after recovery, got %s", e.state)
+	}
+}
+
+func TestHeartbeatUnregistered(t *testing.T) {
+	_, err := s.Heartbeat(context.TODO(), &pb.HeartbeatReq{Uuid: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	conf := stateConf{Store: storeConf{Type: "file", Dir: t.TempDir()}}
+	fs, err := newStateServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"kept", "snapshotted"} {
+		_, err = fs.RegisterForState(context.TODO(),
+			&pb.RegisterReq{Uuid: uuid, Ttl: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = fs.registry.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.ReportState(context.TODO(), &pb.ReportReq{
+		TargetUuid: "kept", State: pb.ReportReq_MAINTANENCE,
+		Reason: "after snapshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//stop without the final snapshot so the report is only in the wal
+	close(fs.done)
+	fs.registry.store.close()
+
+	fs, err = newStateServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.close()
+
+	res, err := fs.ListStates(context.TODO(), &pb.ListStatesReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Infos) != 2 {
+		t.Fatalf("expected 2 restored entities, got %v", res.Infos)
+	}
+	kept := res.Infos[0]
+	if kept.Uuid != "kept" || kept.State != pb.ReportReq_MAINTANENCE ||
+		kept.Reason != "after snapshot" || kept.Ttl != 5 {
+		t.Errorf("unexpected restored entity %v", kept)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	hs, err := newStateServer(stateConf{History_size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.close()
+
+	_, err = hs.RegisterForState(context.TODO(),
+		&pb.RegisterReq{Uuid: "flappy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := hs.GetHistory(context.TODO(),
+		&pb.GetHistoryReq{Uuid: "flappy"})
+	if err != nil || len(res.Transitions) != 0 {
+		t.Errorf("registration recorded a transition %v %v", res, err)
+	}
+	for _, st := range []pb.ReportReq_State{pb.ReportReq_MAINTANENCE,
+		pb.ReportReq_UP, pb.ReportReq_DRAINING, pb.ReportReq_UP} {
+		_, err = hs.ReportState(context.TODO(), &pb.ReportReq{
+			TargetUuid: "flappy", State: st, ReporteeUuid: "lb",
+			Reason: st.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err = hs.GetHistory(context.TODO(),
+		&pb.GetHistoryReq{Uuid: "flappy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transitions) != 3 {
+		t.Fatalf("expected ring of 3, got %v", res.Transitions)
+	}
+	first := res.Transitions[0]
+	if first.PreviousState != pb.ReportReq_MAINTANENCE ||
+		first.State != pb.ReportReq_UP || first.ReporteeUuid != "lb" {
+		t.Errorf("unexpected oldest transition %v", first)
+	}
+
+	since := res.Transitions[1].Timestamp
+	res, err = hs.GetHistory(context.TODO(), &pb.GetHistoryReq{
+		Uuid: "flappy", Since: since, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transitions) != 1 ||
+		res.Transitions[0].State != pb.ReportReq_UP {
+		t.Errorf("expected the newest transition, got %v",
+			res.Transitions)
+	}
+	res, err = hs.GetHistory(context.TODO(), &pb.GetHistoryReq{
+		Uuid: "flappy", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transitions) != 2 ||
+		res.Transitions[0].State != pb.ReportReq_DRAINING ||
+		res.Transitions[1].State != pb.ReportReq_UP {
+		t.Errorf("expected the newest 2 oldest first, got %v",
+			res.Transitions)
+	}
+
+	_, err = hs.GetHistory(context.TODO(),
+		&pb.GetHistoryReq{Uuid: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestWebhookUuidPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		uuid    string
+		match   bool
+	}{
+		{"local@*", "local@unix:/tmp/serverbox-local.sock", true},
+		{"local@unix:/tmp/*", "local@unix:/tmp/serverbox-local.sock", true},
+		{"web@*", "web@localhost:8080", true},
+		{"web@*", "local@unix:/tmp/web.sock", false},
+		{"*.sock", "local@unix:/tmp/serverbox-local.sock", true},
+	}
+	for _, c := range cases {
+		h := &webhook{conf: webhookConf{Uuid: c.pattern}}
+		tr := transition{entity: entity{uuid: c.uuid}}
+		if h.wants(tr) != c.match {
+			t.Errorf("%q matching %q should be %v", c.pattern, c.uuid,
+				c.match)
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	posts := make(chan webhookPayload, 4)
+	failed := false
+	ok := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get(signatureHeader) != sign("secret", body) {
+				t.Error("bad signature")
+			}
+			if !failed {
+				failed = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var payload webhookPayload
+			json.Unmarshal(body, &payload)
+			posts <- payload
+		}))
+	defer ok.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+	defer rejecting.Close()
+
+	dead := filepath.Join(t.TempDir(), "dead")
+	n, err := newNotifier([]webhookConf{
+		{Url: ok.URL, Secret: "secret", States: []string{"UP"},
+			Uuid: "web@*", Max_retries: 2},
+		{Url: rejecting.URL, Uuid: "api@*", Max_retries: 2},
+	}, dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.backoffBase = time.Millisecond
+	n.start()
+
+	n.notify(transition{previous: pb.ReportReq_MAINTANENCE,
+		entity: entity{uuid: "web@a:1", state: pb.ReportReq_DOWN}})
+	n.notify(transition{previous: pb.ReportReq_MAINTANENCE,
+		entity: entity{uuid: "web@a:1", state: pb.ReportReq_UP,
+			reason: "started"}})
+	n.notify(transition{previous: pb.ReportReq_MAINTANENCE,
+		entity: entity{uuid: "api@a:1", state: pb.ReportReq_UP}})
+
+	payload := <-posts
+	if payload.Uuid != "web@a:1" || payload.State != "UP" ||
+		payload.PreviousState != "MAINTANENCE" ||
+		payload.Reason != "started" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	n.close()
+
+	if len(posts) != 0 {
+		t.Errorf("filtered transition was posted")
+	}
+	data, err := os.ReadFile(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"uuid":"api@a:1"`) {
+		t.Errorf("rejected payload not dead lettered: %s", data)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+	defer slow.Close()
+
+	dead := filepath.Join(t.TempDir(), "dead")
+	n, err := newNotifier([]webhookConf{{Url: slow.URL, Queue: 1}}, dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.start()
+
+	//one payload is posted, one queued and the rest dead lettered
+	//without waiting on the log
+	for _, uuid := range []string{"a", "b", "c", "d"} {
+		n.notify(transition{previous: pb.ReportReq_MAINTANENCE,
+			entity: entity{uuid: uuid, state: pb.ReportReq_UP}})
+	}
+	close(release)
+	n.close()
+
+	data, err := os.ReadFile(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "queue full") < 2 {
+		t.Errorf("payloads not dead lettered: %s", data)
+	}
+}
+
+func TestStatsBridgeCollect(t *testing.T) {
+	now := time.Now()
+	b, err := newStatsBridge(stateConf{Host: "localhost", Port: 9002,
+		Statistics: statisticsConf{Host: "localhost", Port: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.conn.Close()
+	b.now = func() time.Time { return now }
+	b.entities["web@a:1"] = &availability{state: pb.ReportReq_MAINTANENCE,
+		since: now, spent: make(map[pb.ReportReq_State]time.Duration),
+		transitions: make(map[pb.ReportReq_State]uint64)}
+
+	now = now.Add(10 * time.Second)
+	b.transition(transition{previous: pb.ReportReq_MAINTANENCE,
+		entity: entity{uuid: "web@a:1", state: pb.ReportReq_UP,
+			lastChange: now}})
+	now = now.Add(30 * time.Second)
+
+	values := make(map[string]float64)
+	metrics, sent := b.collect()
+	for _, m := range metrics {
+		values[m.Name+"/"+m.Labels["state"]] = m.Value
+	}
+	want := map[string]float64{
+		"sb_state_time_in_state_seconds/MAINTANENCE": 10,
+		"sb_state_time_in_state_seconds/UP":          30,
+		"sb_state_uptime_ratio/":                     0.75,
+		"sb_state_transitions_total/UP":              1,
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, values[k])
+		}
+	}
+
+	transitions := func(state string) float64 {
+		metrics, _ := b.collect()
+		for _, m := range metrics {
+			if m.Name == "sb_state_transitions_total" &&
+				m.Labels["state"] == state {
+				return m.Value
+			}
+		}
+		return 0
+	}
+	//a failed push keeps the transitions for the next one
+	if n := transitions("UP"); n != 1 {
+		t.Errorf("expected the unpushed transition again, got %v", n)
+	}
+
+	//a transition while the push is in flight is kept after it succeeds
+	b.transition(transition{previous: pb.ReportReq_UP,
+		entity: entity{uuid: "web@a:1", state: pb.ReportReq_DRAINING,
+			lastChange: now}})
+	b.transition(transition{previous: pb.ReportReq_DRAINING,
+		entity: entity{uuid: "web@a:1", state: pb.ReportReq_UP,
+			lastChange: now}})
+	b.pushed(sent)
+	if n := transitions("UP"); n != 1 {
+		t.Errorf("expected 1 UP transition after the push, got %v", n)
+	}
+	if n := transitions("DRAINING"); n != 1 {
+		t.Errorf("expected 1 DRAINING transition after the push, got %v",
+			n)
+	}
+}
+
+func verifiedAs(names ...string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "other"},
+		DNSNames: names}
+	return peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}}}}})
+}
+
+func TestReportStateIdentity(t *testing.T) {
+	ctx := verifiedAs("web@a:1")
+
+	_, err := s.RegisterForState(ctx, &pb.RegisterReq{Uuid: "web@a:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ReportState(ctx, &pb.ReportReq{TargetUuid: "api@a:1",
+		State: pb.ReportReq_DOWN, ReporteeUuid: "api@a:1"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	//its own identity, but not a reporter for others
+	_, err = s.ReportState(ctx, &pb.ReportReq{TargetUuid: "api@a:1",
+		State: pb.ReportReq_DOWN, ReporteeUuid: "web@a:1"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	_, err = s.ReportState(ctx, &pb.ReportReq{TargetUuid: "web@a:1",
+		State: pb.ReportReq_MAINTANENCE})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = s.Heartbeat(ctx, &pb.HeartbeatReq{Uuid: "web@a:1"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	//the name part alone is only enough when opted in
+	_, err = s.RegisterForState(verifiedAs("web"),
+		&pb.RegisterReq{Uuid: "web@b:1"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	conf := stateConf{Reporters: []string{"lb@*"}}
+	conf.Tls.Match_name = true
+	rs, err := newStateServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.close()
+	_, err = rs.RegisterForState(verifiedAs("web"),
+		&pb.RegisterReq{Uuid: "web@b:1"})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = rs.ReportState(verifiedAs("lb@c:1"), &pb.ReportReq{
+		TargetUuid: "web@b:1", State: pb.ReportReq_DOWN,
+		ReporteeUuid: "lb@c:1"})
+	if err != nil {
+		t.Error(err)
+	}
+	e, _ := rs.registry.lookup("web@b:1")
+	if e.state != pb.ReportReq_DOWN || e.reporteeUuid != "lb@c:1" {
+		t.Errorf("unexpected entity %v", e)
+	}
+
+	_, err = newStateServer(stateConf{Reporters: []string{"lb@["}})
+	if err == nil {
+		t.Error("bad reporter pattern accepted")
+	}
 }
@@ -0,0 +1,270 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"github.com/ramdrjn/serverbox/pkgs/common"
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	spb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+const defaultBridgeInterval = 10 * time.Second
+
+// availability is what the bridge tracks of one entity: the time spent in
+// each state and the transitions into each state not yet pushed.
+type availability struct {
+	state       pb.ReportReq_State
+	since       time.Time
+	spent       map[pb.ReportReq_State]time.Duration
+	transitions map[pb.ReportReq_State]uint64
+}
+
+// statsBridge registers the daemon with the statistics daemon as a STATE
+// source and publishes the availability of every entity to it.
+type statsBridge struct {
+	uuid     string
+	interval time.Duration
+	conn     *grpc.ClientConn
+	client   spb.StatisticsClient
+	enrolled bool
+	now      func() time.Time
+
+	mu       sync.Mutex
+	entities map[string]*availability
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newStatsBridge(conf stateConf) (*statsBridge, error) {
+	link := conf.Statistics
+	host := fmt.Sprintf("%s:%d", link.Host, link.Port)
+	creds := insecure.NewCredentials()
+	if link.Tls.Enabled {
+		config, err := common.ClientTlsConfig(link.Tls)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &statsBridge{
+		uuid:     fmt.Sprintf("state@%s:%d", conf.Host, conf.Port),
+		interval: defaultBridgeInterval,
+		conn:     conn,
+		client:   spb.NewStatisticsClient(conn),
+		now:      time.Now,
+		entities: make(map[string]*availability),
+		done:     make(chan struct{}),
+	}
+	if link.Interval != 0 {
+		b.interval = time.Duration(link.Interval) * time.Second
+	}
+	return b, nil
+}
+
+// start tracks entities from their current state onwards.
+func (b *statsBridge) start(entities []entity) {
+	now := b.now()
+	b.mu.Lock()
+	for _, e := range entities {
+		b.entities[e.uuid] = &availability{
+			state:       e.state,
+			since:       now,
+			spent:       make(map[pb.ReportReq_State]time.Duration),
+			transitions: make(map[pb.ReportReq_State]uint64),
+		}
+	}
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.run()
+}
+
+func (b *statsBridge) close() {
+	close(b.done)
+	b.wg.Wait()
+	b.conn.Close()
+}
+
+// transition never blocks on the statistics daemon, it is called with the
+// registry locked.
+func (b *statsBridge) transition(t transition) {
+	at := t.entity.lastChange
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a, ok := b.entities[t.entity.uuid]
+	if !ok {
+		a = &availability{
+			state:       t.previous,
+			since:       at,
+			spent:       make(map[pb.ReportReq_State]time.Duration),
+			transitions: make(map[pb.ReportReq_State]uint64),
+		}
+		b.entities[t.entity.uuid] = a
+	}
+	if at.After(a.since) {
+		a.spent[a.state] += at.Sub(a.since)
+		a.since = at
+	}
+	a.state = t.entity.state
+	a.transitions[t.entity.state]++
+}
+
+func (b *statsBridge) run() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.push()
+		}
+	}
+}
+
+func (b *statsBridge) register(ctx context.Context) error {
+	req := &spb.RegisterReq{Uuid: b.uuid, Type: spb.RegisterReq_STATE}
+	res, err := b.client.RegisterForStats(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !res.Enrolled {
+		return fmt.Errorf("%s not enrolled", b.uuid)
+	}
+	Log.Infof("registered with statistics as %s", b.uuid)
+	b.enrolled = true
+	return nil
+}
+
+// push registers first if needed, so the statistics daemon may come up
+// after this one or restart in between.
+func (b *statsBridge) push() {
+	ctx, cancel := context.WithTimeout(context.Background(), b.interval)
+	defer cancel()
+
+	if !b.enrolled {
+		err := b.register(ctx)
+		if err != nil {
+			Log.Debug("statistics registration failed: ", err)
+			return
+		}
+	}
+
+	metrics, sent := b.collect()
+	batch := &spb.MetricBatch{Uuid: b.uuid, Metrics: metrics}
+	stream, err := b.client.PushMetrics(ctx)
+	if err == nil {
+		err = stream.Send(batch)
+	}
+	if err == nil {
+		_, err = stream.CloseAndRecv()
+	}
+	if err != nil {
+		Log.Debug("statistics push failed: ", err)
+		if status.Code(err) == codes.NotFound {
+			b.enrolled = false
+		}
+		return
+	}
+	b.pushed(sent)
+}
+
+// transitionCounts are the transitions into each state, per entity.
+type transitionCounts map[string]map[pb.ReportReq_State]uint64
+
+// pushed forgets the transitions a push delivered. The ones that happened
+// while it was in flight are kept for the next push.
+func (b *statsBridge) pushed(sent transitionCounts) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for uuid, counts := range sent {
+		a, ok := b.entities[uuid]
+		if !ok {
+			continue
+		}
+		for st, n := range counts {
+			a.transitions[st] -= n
+			if a.transitions[st] == 0 {
+				delete(a.transitions, st)
+			}
+		}
+	}
+}
+
+// collect reports, per entity, the seconds spent in each state and the
+// share of it spent UP since the daemon started tracking the entity, plus
+// the transitions into each state not pushed yet. The transitions
+// collected are returned as well, for pushed once they are delivered.
+func (b *statsBridge) collect() ([]*spb.Metric, transitionCounts) {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var metrics []*spb.Metric
+	sent := make(transitionCounts)
+	for uuid, a := range b.entities {
+		spent := make(map[pb.ReportReq_State]time.Duration)
+		var total time.Duration
+		for st, d := range a.spent {
+			spent[st] = d
+			total += d
+		}
+		if now.After(a.since) {
+			spent[a.state] += now.Sub(a.since)
+			total += now.Sub(a.since)
+		}
+
+		for st, d := range spent {
+			metrics = append(metrics, &spb.Metric{
+				Name: "sb_state_time_in_state_seconds",
+				Type: spb.Metric_GAUGE,
+				Labels: map[string]string{"entity": uuid,
+					"state": st.String()},
+				Value: d.Seconds(),
+			})
+		}
+		if total != 0 {
+			metrics = append(metrics, &spb.Metric{
+				Name:   "sb_state_uptime_ratio",
+				Type:   spb.Metric_GAUGE,
+				Labels: map[string]string{"entity": uuid},
+				Value: float64(spent[pb.ReportReq_UP]) /
+					float64(total),
+			})
+		}
+		if len(a.transitions) != 0 {
+			sent[uuid] = make(map[pb.ReportReq_State]uint64)
+		}
+		for st, n := range a.transitions {
+			metrics = append(metrics, &spb.Metric{
+				Name: "sb_state_transitions_total",
+				Type: spb.Metric_COUNTER,
+				Labels: map[string]string{"entity": uuid,
+					"state": st.String()},
+				Value: float64(n),
+			})
+			sent[uuid][st] = n
+		}
+	}
+	return metrics, sent
+}
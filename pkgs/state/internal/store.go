@@ -0,0 +1,242 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "state.wal"
+	snapshotFile = "state.snapshot"
+)
+
+// record is the persisted form of an entity. Heartbeats are not persisted,
+// a restored lease starts afresh when the daemon comes up.
+type record struct {
+	Uuid         string              `json:"uuid"`
+	Type         pb.RegisterReq_Type `json:"type"`
+	State        pb.ReportReq_State  `json:"state"`
+	RegisteredAt time.Time           `json:"registered_at"`
+	LastChange   time.Time           `json:"last_change"`
+	ReporteeUuid string              `json:"reportee_uuid"`
+	Reason       string              `json:"reason"`
+	Ttl          time.Duration       `json:"ttl"`
+}
+
+func toRecord(e *entity) record {
+	return record{
+		Uuid:         e.uuid,
+		Type:         e.eType,
+		State:        e.state,
+		RegisteredAt: e.registeredAt,
+		LastChange:   e.lastChange,
+		ReporteeUuid: e.reporteeUuid,
+		Reason:       e.reason,
+		Ttl:          e.ttl,
+	}
+}
+
+func (rec record) toEntity() *entity {
+	return &entity{
+		uuid:         rec.Uuid,
+		eType:        rec.Type,
+		state:        rec.State,
+		registeredAt: rec.RegisteredAt,
+		lastChange:   rec.LastChange,
+		reporteeUuid: rec.ReporteeUuid,
+		reason:       rec.Reason,
+		ttl:          rec.Ttl,
+	}
+}
+
+// store is the storage backend of the registry. put is called for every
+// change in the order the changes are applied, snapshot with the complete
+// registry while no put can happen.
+type store interface {
+	load() ([]record, error)
+	put(rec record) error
+	snapshot(recs []record) error
+	close() error
+}
+
+func openStore(conf storeConf) (store, error) {
+	switch conf.Type {
+	case "", "memory":
+		return memoryStore{}, nil
+	case "file":
+		return openFileStore(conf.Dir)
+	}
+	return nil, fmt.Errorf("invalid store type %s", conf.Type)
+}
+
+// memoryStore keeps nothing, the registry itself is the only copy.
+type memoryStore struct{}
+
+func (memoryStore) load() ([]record, error) {
+	return nil, nil
+}
+
+func (memoryStore) put(rec record) error {
+	return nil
+}
+
+func (memoryStore) snapshot(recs []record) error {
+	return nil
+}
+
+func (memoryStore) close() error {
+	return nil
+}
+
+// fileStore appends every change to a write-ahead log and periodically
+// folds the log into a snapshot file.
+type fileStore struct {
+	mu  sync.Mutex
+	dir string
+	wal *os.File
+}
+
+func openFileStore(dir string) (*fileStore, error) {
+	if dir == "" {
+		return nil, errors.New("file store needs a dir")
+	}
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFile),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir, wal: wal}, nil
+}
+
+// load replays the log over the snapshot. A torn last line, left by a
+// crash while appending, is skipped.
+func (f *fileStore) load() ([]record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records := make(map[string]record)
+	var order []string
+	apply := func(rec record) {
+		if _, ok := records[rec.Uuid]; !ok {
+			order = append(order, rec.Uuid)
+		}
+		records[rec.Uuid] = rec
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var recs []record
+		err = json.Unmarshal(data, &recs)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt snapshot: %w", err)
+		}
+		for _, rec := range recs {
+			apply(rec)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(f.dir, walFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			Log.Error("skipping unreadable wal entry: ", err)
+			continue
+		}
+		apply(rec)
+	}
+	//terminate a torn line so the next entry does not get glued to it
+	if len(data) != 0 && data[len(data)-1] != '\n' {
+		_, err = f.wal.Write([]byte{'\n'})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	recs := make([]record, 0, len(order))
+	for _, uuid := range order {
+		recs = append(recs, records[uuid])
+	}
+	return recs, nil
+}
+
+func (f *fileStore) put(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.wal.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	return f.wal.Sync()
+}
+
+// snapshot replaces the snapshot file atomically and only then truncates
+// the log, so a crash in between replays entries already in the snapshot.
+func (f *fileStore) snapshot(recs []record) error {
+	data, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := filepath.Join(f.dir, snapshotFile)
+	tmp, err := os.CreateTemp(f.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = f.wal.Truncate(0)
+	if err != nil {
+		return err
+	}
+	return f.wal.Sync()
+}
+
+func (f *fileStore) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.wal.Close()
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"sync"
+)
+
+const defaultWatchBuffer = 64
+
+type watcher struct {
+	uuids  map[string]bool
+	events chan transition
+	// resync is signalled once the events buffer overflowed and
+	// transitions were dropped for this watcher.
+	resync chan struct{}
+}
+
+func (w *watcher) wants(uuid string) bool {
+	return len(w.uuids) == 0 || w.uuids[uuid]
+}
+
+type broker struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	bufSize  int
+}
+
+func newBroker(bufSize int) *broker {
+	if bufSize <= 0 {
+		bufSize = defaultWatchBuffer
+	}
+	return &broker{
+		watchers: make(map[*watcher]struct{}),
+		bufSize:  bufSize,
+	}
+}
+
+func (b *broker) subscribe(uuids []string) *watcher {
+	w := &watcher{
+		uuids:  make(map[string]bool),
+		events: make(chan transition, b.bufSize),
+		resync: make(chan struct{}, 1),
+	}
+	for _, uuid := range uuids {
+		w.uuids[uuid] = true
+	}
+
+	b.mu.Lock()
+	b.watchers[w] = struct{}{}
+	b.mu.Unlock()
+	return w
+}
+
+func (b *broker) unsubscribe(w *watcher) {
+	b.mu.Lock()
+	delete(b.watchers, w)
+	b.mu.Unlock()
+}
+
+// publish never blocks. A watcher that is not keeping up loses the
+// transition and is told to resync instead.
+func (b *broker) publish(t transition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for w := range b.watchers {
+		if !w.wants(t.entity.uuid) {
+			continue
+		}
+		select {
+		case w.events <- t:
+		default:
+			select {
+			case w.resync <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package state
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	pb "github.com/ramdrjn/serverbox/pkgs/state/pkgs/sb_state_proto"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookQueue   = 256
+	defaultWebhookTimeout = 5 * time.Second
+	webhookBackoffBase    = 500 * time.Millisecond
+	webhookBackoffMax     = 30 * time.Second
+	signatureHeader       = "X-Serverbox-Signature"
+)
+
+type webhookPayload struct {
+	Uuid          string    `json:"uuid"`
+	PreviousState string    `json:"previous_state"`
+	State         string    `json:"state"`
+	Reason        string    `json:"reason"`
+	ReporteeUuid  string    `json:"reportee_uuid"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type webhook struct {
+	conf    webhookConf
+	states  map[pb.ReportReq_State]bool
+	timeout time.Duration
+	queue   chan webhookPayload
+}
+
+func (h *webhook) wants(t transition) bool {
+	if len(h.states) != 0 && !h.states[t.entity.state] {
+		return false
+	}
+	if h.conf.Uuid == "" {
+		return true
+	}
+	ok, _ := matchUuid(h.conf.Uuid, t.entity.uuid)
+	return ok
+}
+
+// matchUuid is path.Match with * and ? matching "/" as well, as the uuids
+// of servers bound to unix sockets hold a path.
+func matchUuid(pattern string, uuid string) (bool, error) {
+	return path.Match(strings.ReplaceAll(pattern, "/", "\x00"),
+		strings.ReplaceAll(uuid, "/", "\x00"))
+}
+
+// notifier posts transitions to the configured webhooks. Each hook has its
+// own queue and worker so a slow endpoint only delays itself. Payloads
+// that cannot be delivered end up in the dead letter log, written by a
+// worker of its own.
+type notifier struct {
+	hooks       []*webhook
+	client      *http.Client
+	backoffBase time.Duration
+	deadLetter  string
+	dead        chan deadLetter
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	deadDone chan struct{}
+	deadWg   sync.WaitGroup
+}
+
+func newNotifier(confs []webhookConf, deadLog string) (*notifier, error) {
+	n := &notifier{
+		client:      &http.Client{},
+		backoffBase: webhookBackoffBase,
+		deadLetter:  deadLog,
+		dead:        make(chan deadLetter, defaultWebhookQueue),
+		done:        make(chan struct{}),
+		deadDone:    make(chan struct{}),
+	}
+
+	for _, conf := range confs {
+		if conf.Url == "" {
+			return nil, fmt.Errorf("webhook without url")
+		}
+		_, err := matchUuid(conf.Uuid, "")
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s uuid pattern %q: %w",
+				conf.Url, conf.Uuid, err)
+		}
+
+		h := &webhook{
+			conf:    conf,
+			states:  make(map[pb.ReportReq_State]bool),
+			timeout: defaultWebhookTimeout,
+		}
+		for _, name := range conf.States {
+			st, ok := pb.ReportReq_State_value[name]
+			if !ok {
+				return nil, fmt.Errorf("webhook %s invalid state %s",
+					conf.Url, name)
+			}
+			h.states[pb.ReportReq_State(st)] = true
+		}
+		if conf.Timeout != 0 {
+			h.timeout = time.Duration(conf.Timeout) * time.Second
+		}
+		size := conf.Queue
+		if size <= 0 {
+			size = defaultWebhookQueue
+		}
+		h.queue = make(chan webhookPayload, size)
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+func (n *notifier) start() {
+	n.deadWg.Add(1)
+	go n.writeDeadLetters()
+	for _, h := range n.hooks {
+		n.wg.Add(1)
+		go n.deliver(h)
+	}
+}
+
+// close stops retrying, dead letters whatever is still queued and waits
+// for the workers to finish.
+func (n *notifier) close() {
+	close(n.done)
+	n.wg.Wait()
+	close(n.deadDone)
+	n.deadWg.Wait()
+}
+
+// notify never blocks, it is called with the registry locked.
+func (n *notifier) notify(t transition) {
+	payload := webhookPayload{
+		Uuid:          t.entity.uuid,
+		PreviousState: t.previous.String(),
+		State:         t.entity.state.String(),
+		Reason:        t.entity.reason,
+		ReporteeUuid:  t.entity.reporteeUuid,
+		Timestamp:     t.entity.lastChange,
+	}
+	for _, h := range n.hooks {
+		if !h.wants(t) {
+			continue
+		}
+		select {
+		case h.queue <- payload:
+			continue
+		default:
+		}
+		l, ok := n.drop(h, payload, "queue full")
+		if !ok {
+			continue
+		}
+		select {
+		case n.dead <- l:
+		default:
+			Log.Errorf("dead letter queue full, lost transition of %s",
+				payload.Uuid)
+		}
+	}
+}
+
+func (n *notifier) deliver(h *webhook) {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.done:
+			for {
+				select {
+				case payload := <-h.queue:
+					n.deadLetterPayload(h, payload, "shutting down")
+				default:
+					return
+				}
+			}
+		case payload := <-h.queue:
+			err := n.post(h, payload)
+			if err != nil {
+				n.deadLetterPayload(h, payload, err.Error())
+			}
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// post retries with exponential backoff up to the configured number of
+// retries, except for client errors which will not succeed on retry.
+func (n *notifier) post(h *webhook, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := n.backoffBase
+	for attempt := 0; ; attempt++ {
+		err = n.postOnce(h, body)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(permanentError); ok {
+			return err
+		}
+		if attempt >= h.conf.Max_retries {
+			return fmt.Errorf("giving up after %d attempts: %w",
+				attempt+1, err)
+		}
+
+		Log.Debugf("webhook %s failed, retrying in %s: %s",
+			h.conf.Url, delay, err)
+		select {
+		case <-n.done:
+			return fmt.Errorf("shutting down: %w", err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > webhookBackoffMax {
+			delay = webhookBackoffMax
+		}
+	}
+}
+
+func (n *notifier) postOnce(h *webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.conf.Url,
+		bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.conf.Secret != "" {
+		req.Header.Set(signatureHeader, sign(h.conf.Secret, body))
+	}
+
+	client := *n.client
+	client.Timeout = h.timeout
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusRequestTimeout,
+		res.StatusCode == http.StatusTooManyRequests,
+		res.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return permanentError{fmt.Errorf("webhook returned %s", res.Status)}
+}
+
+// sign returns the hex HMAC-SHA256 of body, prefixed by the algorithm.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type deadLetter struct {
+	Url     string         `json:"url"`
+	Error   string         `json:"error"`
+	Payload webhookPayload `json:"payload"`
+}
+
+// drop logs the payload as lost and returns its dead letter, if there is
+// a dead letter log to write it to.
+func (n *notifier) drop(h *webhook, payload webhookPayload, reason string) (deadLetter, bool) {
+	Log.Errorf("webhook %s dropped transition of %s: %s", h.conf.Url,
+		payload.Uuid, reason)
+	if n.deadLetter == "" {
+		return deadLetter{}, false
+	}
+	return deadLetter{h.conf.Url, reason, payload}, true
+}
+
+// deadLetterPayload hands the payload to the dead letter writer, waiting
+// for room in its queue. Only the delivery workers call it.
+func (n *notifier) deadLetterPayload(h *webhook, payload webhookPayload, reason string) {
+	l, ok := n.drop(h, payload, reason)
+	if ok {
+		n.dead <- l
+	}
+}
+
+// writeDeadLetters appends the dead letters to the log until the notifier
+// is closed, then writes whatever is left queued.
+func (n *notifier) writeDeadLetters() {
+	defer n.deadWg.Done()
+
+	for {
+		select {
+		case l := <-n.dead:
+			n.writeDeadLetter(l)
+		case <-n.deadDone:
+			for {
+				select {
+				case l := <-n.dead:
+					n.writeDeadLetter(l)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *notifier) writeDeadLetter(l deadLetter) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		Log.Error("dead letter encoding failed: ", err)
+		return
+	}
+
+	f, err := os.OpenFile(n.deadLetter,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		Log.Error("dead letter log failed: ", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		Log.Error("dead letter log failed: ", err)
+	}
+}
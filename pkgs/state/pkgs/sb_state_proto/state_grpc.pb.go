@@ -20,6 +20,11 @@ const _ = grpc.SupportPackageIsVersion7
 type StateClient interface {
 	RegisterForState(ctx context.Context, in *RegisterReq, opts ...grpc.CallOption) (*RegisterRes, error)
 	ReportState(ctx context.Context, in *ReportReq, opts ...grpc.CallOption) (*ReportRes, error)
+	Heartbeat(ctx context.Context, in *HeartbeatReq, opts ...grpc.CallOption) (*HeartbeatRes, error)
+	GetState(ctx context.Context, in *GetStateReq, opts ...grpc.CallOption) (*GetStateRes, error)
+	ListStates(ctx context.Context, in *ListStatesReq, opts ...grpc.CallOption) (*ListStatesRes, error)
+	GetHistory(ctx context.Context, in *GetHistoryReq, opts ...grpc.CallOption) (*GetHistoryRes, error)
+	WatchState(ctx context.Context, in *WatchReq, opts ...grpc.CallOption) (State_WatchStateClient, error)
 }
 
 type stateClient struct {
@@ -48,12 +53,85 @@ func (c *stateClient) ReportState(ctx context.Context, in *ReportReq, opts ...gr
 	return out, nil
 }
 
+func (c *stateClient) Heartbeat(ctx context.Context, in *HeartbeatReq, opts ...grpc.CallOption) (*HeartbeatRes, error) {
+	out := new(HeartbeatRes)
+	err := c.cc.Invoke(ctx, "/sb_state_proto.State/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetState(ctx context.Context, in *GetStateReq, opts ...grpc.CallOption) (*GetStateRes, error) {
+	out := new(GetStateRes)
+	err := c.cc.Invoke(ctx, "/sb_state_proto.State/GetState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) ListStates(ctx context.Context, in *ListStatesReq, opts ...grpc.CallOption) (*ListStatesRes, error) {
+	out := new(ListStatesRes)
+	err := c.cc.Invoke(ctx, "/sb_state_proto.State/ListStates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetHistory(ctx context.Context, in *GetHistoryReq, opts ...grpc.CallOption) (*GetHistoryRes, error) {
+	out := new(GetHistoryRes)
+	err := c.cc.Invoke(ctx, "/sb_state_proto.State/GetHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) WatchState(ctx context.Context, in *WatchReq, opts ...grpc.CallOption) (State_WatchStateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &State_ServiceDesc.Streams[0], "/sb_state_proto.State/WatchState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &stateWatchStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type State_WatchStateClient interface {
+	Recv() (*StateEvent, error)
+	grpc.ClientStream
+}
+
+type stateWatchStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *stateWatchStateClient) Recv() (*StateEvent, error) {
+	m := new(StateEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // StateServer is the server API for State service.
 // All implementations must embed UnimplementedStateServer
 // for forward compatibility
 type StateServer interface {
 	RegisterForState(context.Context, *RegisterReq) (*RegisterRes, error)
 	ReportState(context.Context, *ReportReq) (*ReportRes, error)
+	Heartbeat(context.Context, *HeartbeatReq) (*HeartbeatRes, error)
+	GetState(context.Context, *GetStateReq) (*GetStateRes, error)
+	ListStates(context.Context, *ListStatesReq) (*ListStatesRes, error)
+	GetHistory(context.Context, *GetHistoryReq) (*GetHistoryRes, error)
+	WatchState(*WatchReq, State_WatchStateServer) error
 	mustEmbedUnimplementedStateServer()
 }
 
@@ -67,6 +145,21 @@ func (UnimplementedStateServer) RegisterForState(context.Context, *RegisterReq)
 func (UnimplementedStateServer) ReportState(context.Context, *ReportReq) (*ReportRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReportState not implemented")
 }
+func (UnimplementedStateServer) Heartbeat(context.Context, *HeartbeatReq) (*HeartbeatRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedStateServer) GetState(context.Context, *GetStateReq) (*GetStateRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetState not implemented")
+}
+func (UnimplementedStateServer) ListStates(context.Context, *ListStatesReq) (*ListStatesRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStates not implemented")
+}
+func (UnimplementedStateServer) GetHistory(context.Context, *GetHistoryReq) (*GetHistoryRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedStateServer) WatchState(*WatchReq, State_WatchStateServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchState not implemented")
+}
 func (UnimplementedStateServer) mustEmbedUnimplementedStateServer() {}
 
 // UnsafeStateServer may be embedded to opt out of forward compatibility for this service.
@@ -116,6 +209,99 @@ func _State_ReportState_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _State_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sb_state_proto.State/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).Heartbeat(ctx, req.(*HeartbeatReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sb_state_proto.State/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetState(ctx, req.(*GetStateReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_ListStates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStatesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).ListStates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sb_state_proto.State/ListStates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).ListStates(ctx, req.(*ListStatesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_GetHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHistoryReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StateServer).GetHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sb_state_proto.State/GetHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StateServer).GetHistory(ctx, req.(*GetHistoryReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _State_WatchState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StateServer).WatchState(m, &stateWatchStateServer{stream})
+}
+
+type State_WatchStateServer interface {
+	Send(*StateEvent) error
+	grpc.ServerStream
+}
+
+type stateWatchStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *stateWatchStateServer) Send(m *StateEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // State_ServiceDesc is the grpc.ServiceDesc for State service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -131,7 +317,29 @@ var State_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportState",
 			Handler:    _State_ReportState_Handler,
 		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _State_Heartbeat_Handler,
+		},
+		{
+			MethodName: "GetState",
+			Handler:    _State_GetState_Handler,
+		},
+		{
+			MethodName: "ListStates",
+			Handler:    _State_ListStates_Handler,
+		},
+		{
+			MethodName: "GetHistory",
+			Handler:    _State_GetHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchState",
+			Handler:       _State_WatchState_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "state.proto",
 }
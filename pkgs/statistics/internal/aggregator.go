@@ -0,0 +1,337 @@
+package statistics
+
+import (
+	"fmt"
+	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// series is the aggregate of one metric name and label set of a source.
+// Counters, histogram buckets, counts and sums accumulate the increments
+// pushed by the client; gauges and quantiles keep the latest value.
+type series struct {
+	name      string
+	mType     pb.Metric_Type
+	labels    map[string]string
+	help      string
+	value     float64
+	bounds    []float64
+	buckets   []uint64
+	count     uint64
+	sum       float64
+	quantiles []*pb.Quantile
+	updated   time.Time
+	history   *timeseries
+}
+
+type source struct {
+	uuid         string
+	sType        pb.RegisterReq_Type
+	registeredAt time.Time
+	series       map[string]*series
+	tiers        []tier
+}
+
+type aggregator struct {
+	mu      sync.RWMutex
+	sources map[string]*source
+	tiers   []tier
+	now     func() time.Time
+}
+
+func newAggregator(tiers []tier) *aggregator {
+	return &aggregator{
+		sources: make(map[string]*source),
+		tiers:   tiers,
+		now:     time.Now,
+	}
+}
+
+// register keeps the series of a uuid that registers again, increments
+// pushed after a restart continue the totals.
+func (a *aggregator) register(uuid string, sType pb.RegisterReq_Type) error {
+	if uuid == "" {
+		return status.Error(codes.InvalidArgument, "uuid is required")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	src, ok := a.sources[uuid]
+	if !ok {
+		src = &source{uuid: uuid, series: make(map[string]*series),
+			tiers: a.tiers}
+		a.sources[uuid] = src
+	}
+	src.sType = sType
+	src.registeredAt = a.now()
+	return nil
+}
+
+// push aggregates a batch and returns how many metrics were accepted and
+// rejected. A batch for an unregistered uuid fails as a whole.
+func (a *aggregator) push(batch *pb.MetricBatch) (accepted uint64, rejected uint64, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	src, ok := a.sources[batch.Uuid]
+	if !ok {
+		return 0, 0, status.Errorf(codes.NotFound,
+			"%s is not registered", batch.Uuid)
+	}
+
+	now := a.now()
+	for _, m := range batch.Metrics {
+		err := src.apply(m, now)
+		if err != nil {
+			Log.Debugf("%s: rejected metric %s: %s", src.uuid,
+				m.Name, err)
+			rejected++
+			continue
+		}
+		accepted++
+	}
+	return accepted, rejected, nil
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, n := range names {
+		fmt.Fprintf(&b, ",%s=%q", n, labels[n])
+	}
+	return b.String()
+}
+
+func validateMetric(m *pb.Metric) error {
+	if !metricNameRe.MatchString(m.Name) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	for n := range m.Labels {
+		if !labelNameRe.MatchString(n) || strings.HasPrefix(n, "__") {
+			return fmt.Errorf("invalid label name %q", n)
+		}
+	}
+
+	switch m.Type {
+	case pb.Metric_COUNTER:
+		if m.Value < 0 {
+			return fmt.Errorf("negative counter increment %v", m.Value)
+		}
+	case pb.Metric_GAUGE:
+	case pb.Metric_HISTOGRAM:
+		if len(m.BucketCounts) != len(m.Bounds)+1 {
+			return fmt.Errorf("%d bucket counts for %d bounds",
+				len(m.BucketCounts), len(m.Bounds))
+		}
+		if !sort.Float64sAreSorted(m.Bounds) {
+			return fmt.Errorf("bounds are not ascending")
+		}
+	case pb.Metric_SUMMARY:
+	default:
+		return fmt.Errorf("invalid metric type %d", m.Type)
+	}
+	return nil
+}
+
+func (src *source) apply(m *pb.Metric, now time.Time) error {
+	err := validateMetric(m)
+	if err != nil {
+		return err
+	}
+
+	key := seriesKey(m.Name, m.Labels)
+	s, ok := src.series[key]
+	if !ok {
+		s = &series{
+			name:    m.Name,
+			mType:   m.Type,
+			labels:  make(map[string]string),
+			bounds:  append([]float64(nil), m.Bounds...),
+			history: newTimeseries(src.tiers),
+		}
+		for n, v := range m.Labels {
+			s.labels[n] = v
+		}
+		if m.Type == pb.Metric_HISTOGRAM {
+			s.buckets = make([]uint64, len(m.Bounds)+1)
+		}
+	}
+	if s.mType != m.Type {
+		return fmt.Errorf("type %s does not match %s", m.Type, s.mType)
+	}
+	if m.Type == pb.Metric_HISTOGRAM && !sameBounds(s.bounds, m.Bounds) {
+		return fmt.Errorf("bounds do not match earlier pushes")
+	}
+
+	switch m.Type {
+	case pb.Metric_COUNTER:
+		s.value += m.Value
+	case pb.Metric_GAUGE:
+		s.value = m.Value
+	case pb.Metric_HISTOGRAM:
+		for i, c := range m.BucketCounts {
+			s.buckets[i] += c
+		}
+		s.count += m.Count
+		s.sum += m.Sum
+	case pb.Metric_SUMMARY:
+		s.count += m.Count
+		s.sum += m.Sum
+		s.quantiles = m.Quantiles
+	}
+	if m.Help != "" {
+		s.help = m.Help
+	}
+	s.updated = now
+	s.history.record(point{now, s.value, s.count, s.sum})
+	src.series[key] = s
+	return nil
+}
+
+func sameBounds(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sample is a copy of a series together with the uuid it was pushed by.
+type sample struct {
+	uuid string
+	series
+}
+
+// family groups the samples of one metric name across all sources.
+type family struct {
+	name    string
+	mType   pb.Metric_Type
+	help    string
+	samples []sample
+}
+
+// families returns copies of every series grouped by metric name, ordered
+// by name, uuid and labels. A series whose type conflicts with the type
+// the name has in another source is left out.
+func (a *aggregator) families() []family {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	uuids := make([]string, 0, len(a.sources))
+	for uuid := range a.sources {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	byName := make(map[string]*family)
+	for _, uuid := range uuids {
+		src := a.sources[uuid]
+		keys := make([]string, 0, len(src.series))
+		for key := range src.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := src.series[key]
+			f, ok := byName[s.name]
+			if !ok {
+				f = &family{name: s.name, mType: s.mType}
+				byName[s.name] = f
+			}
+			if f.mType != s.mType {
+				continue
+			}
+			if f.help == "" {
+				f.help = s.help
+			}
+			c := *s
+			c.buckets = append([]uint64(nil), s.buckets...)
+			f.samples = append(f.samples, sample{uuid, c})
+		}
+	}
+
+	families := make([]family, 0, len(byName))
+	for _, f := range byName {
+		families = append(families, *f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	return families
+}
+
+// seriesRange is the history of one series selected by a range query.
+type seriesRange struct {
+	labels map[string]string
+	mType  pb.Metric_Type
+	points []point
+}
+
+func hasLabels(labels map[string]string, want map[string]string) bool {
+	for n, v := range want {
+		if labels[n] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// query returns the history of every series of metric pushed by uuid that
+// carries all of labels, and the step the points were downsampled to.
+func (a *aggregator) query(uuid string, metric string, labels map[string]string, from time.Time, to time.Time, step time.Duration) ([]seriesRange, time.Duration, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	src, ok := a.sources[uuid]
+	if !ok {
+		return nil, 0, status.Errorf(codes.NotFound,
+			"%s is not registered", uuid)
+	}
+	if to.IsZero() {
+		to = a.now()
+	}
+	if to.Before(from) {
+		return nil, 0, status.Error(codes.InvalidArgument,
+			"range ends before it starts")
+	}
+
+	keys := make([]string, 0, len(src.series))
+	for key, s := range src.series {
+		if s.name == metric && hasLabels(s.labels, labels) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	tier, step := pickTier(src.tiers, from, step, a.now())
+	var ranges []seriesRange
+	for _, key := range keys {
+		s := src.series[key]
+		points := s.history.query(tier, from, to, step)
+		ranges = append(ranges, seriesRange{s.labels, s.mType, points})
+	}
+	return ranges, step, nil
+}
@@ -5,13 +5,29 @@ import (
 )
 
 type StatsContext struct {
-	Log  common.Logger
-	Conf statisticsConf
+	Log    common.Logger
+	Conf   statisticsConf
+	server *statisticsServer
 }
 
 var Log common.Logger
 
 type statisticsConf struct {
-	Host string
-	Port uint32
+	Host       string
+	Port       uint32
+	Prometheus prometheusConf
+	Retention  []retentionConf
+	Tls        common.TlsConf
+}
+
+type retentionConf struct {
+	Resolution uint32
+	Duration   uint32
+}
+
+type prometheusConf struct {
+	Enabled bool
+	Host    string
+	Port    uint32
+	Path    string
 }
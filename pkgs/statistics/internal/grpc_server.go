@@ -2,7 +2,9 @@ package statistics
 
 import (
 	"fmt"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"net"
 )
 
@@ -18,9 +20,19 @@ func InitializeGrpcServer(stc *StatsContext) error {
 		return err
 	}
 
-	grpcServer = grpc.NewServer()
+	var opts []grpc.ServerOption
+	if stc.Conf.Tls.Enabled {
+		config, err := common.ServerTlsConfig(stc.Conf.Tls)
+		if err != nil {
+			stc.Log.Error("tls configuration failed: ", err)
+			lis.Close()
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	grpcServer = grpc.NewServer(opts...)
 
-	RegisterService_SB_Stats(grpcServer)
+	RegisterService_SB_Stats(grpcServer, stc)
 
 	stc.Log.Infof("starting grpc server on %s", host)
 	grpcServer.Serve(lis)
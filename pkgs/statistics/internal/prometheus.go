@@ -0,0 +1,162 @@
+package statistics
+
+import (
+	"bufio"
+	"fmt"
+	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultPrometheusPath = "/metrics"
+
+var promServer *http.Server
+
+var promTypes = map[pb.Metric_Type]string{
+	pb.Metric_COUNTER:   "counter",
+	pb.Metric_GAUGE:     "gauge",
+	pb.Metric_HISTOGRAM: "histogram",
+	pb.Metric_SUMMARY:   "summary",
+}
+
+func InitializePrometheus(stc *StatsContext) error {
+	conf := stc.Conf.Prometheus
+	if !conf.Enabled {
+		return nil
+	}
+	host := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+	path := conf.Path
+	if path == "" {
+		path = defaultPrometheusPath
+	}
+
+	lis, err := net.Listen("tcp", host)
+	if err != nil {
+		stc.Log.Errorf("prometheus on %s listen failed: %s", host, err)
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, prometheusHandler(stc.server.aggregator))
+	promServer = &http.Server{Handler: mux}
+
+	stc.Log.Infof("serving prometheus metrics on %s%s", host, path)
+	go func() {
+		err := promServer.Serve(lis)
+		if err != http.ErrServerClosed {
+			stc.Log.Error("prometheus server failed: ", err)
+		}
+	}()
+	return nil
+}
+
+func ShutDownPrometheus(stc *StatsContext) error {
+	if promServer == nil {
+		return nil
+	}
+	stc.Log.Info("stopping prometheus server")
+	return promServer.Close()
+}
+
+func prometheusHandler(a *aggregator) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type",
+			"text/plain; version=0.0.4; charset=utf-8")
+		w := bufio.NewWriter(res)
+		writePrometheus(w, a.families())
+		w.Flush()
+	})
+}
+
+// writePrometheus renders families in the Prometheus text exposition
+// format, with the uuid of the pushing source as an extra label.
+func writePrometheus(w io.Writer, families []family) {
+	for _, f := range families {
+		if f.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, promTypes[f.mType])
+
+		for _, s := range f.samples {
+			labels := promLabels(s.uuid, s.labels)
+			switch f.mType {
+			case pb.Metric_COUNTER, pb.Metric_GAUGE:
+				writeSample(w, f.name, labels, "", s.value)
+			case pb.Metric_HISTOGRAM:
+				var cumulative uint64
+				for i, c := range s.buckets {
+					cumulative += c
+					le := "+Inf"
+					if i < len(s.bounds) {
+						le = formatFloat(s.bounds[i])
+					}
+					writeSample(w, f.name+"_bucket", labels,
+						`le="`+le+`"`, float64(cumulative))
+				}
+				writeSample(w, f.name+"_sum", labels, "", s.sum)
+				writeSample(w, f.name+"_count", labels, "",
+					float64(s.count))
+			case pb.Metric_SUMMARY:
+				for _, q := range s.quantiles {
+					writeSample(w, f.name, labels,
+						`quantile="`+formatFloat(q.Quantile)+`"`,
+						q.Value)
+				}
+				writeSample(w, f.name+"_sum", labels, "", s.sum)
+				writeSample(w, f.name+"_count", labels, "",
+					float64(s.count))
+			}
+		}
+	}
+}
+
+func promLabels(uuid string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		if n != "uuid" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := []string{`uuid="` + escapeLabel(uuid) + `"`}
+	for _, n := range names {
+		pairs = append(pairs, n+`="`+escapeLabel(labels[n])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func writeSample(w io.Writer, name string, labels string, extra string, value float64) {
+	if extra != "" {
+		labels += "," + extra
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
@@ -2,20 +2,117 @@ package statistics
 
 import (
 	"context"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"time"
 )
 
 type statisticsServer struct {
 	pb.UnimplementedStatisticsServer
+	aggregator *aggregator
+	matchName  bool
 }
 
-func RegisterService_SB_Stats(grpcServer grpc.ServiceRegistrar) {
-	pb.RegisterStatisticsServer(grpcServer, &statisticsServer{})
+func newStatisticsServer(conf statisticsConf) (*statisticsServer, error) {
+	tiers, err := newTiers(conf.Retention)
+	if err != nil {
+		return nil, err
+	}
+	return &statisticsServer{aggregator: newAggregator(tiers),
+		matchName: conf.Tls.Match_name}, nil
 }
 
-func (*statisticsServer) RegisterForStats(ctx context.Context, req *pb.RegisterReq) (res *pb.RegisterRes, err error) {
+func InitializeStatisticsServer(stc *StatsContext) (err error) {
+	stc.server, err = newStatisticsServer(stc.Conf)
+	if err != nil {
+		stc.Log.Error("statistics server failed: ", err)
+	}
+	return err
+}
+
+func RegisterService_SB_Stats(grpcServer grpc.ServiceRegistrar, stc *StatsContext) {
+	pb.RegisterStatisticsServer(grpcServer, stc.server)
+}
+
+func (s *statisticsServer) RegisterForStats(ctx context.Context, req *pb.RegisterReq) (res *pb.RegisterRes, err error) {
 	r := &pb.RegisterRes{}
+	err = common.CheckIdentity(ctx, req.Uuid, s.matchName)
+	if err == nil {
+		err = s.aggregator.register(req.Uuid, req.Type)
+	}
+	if err != nil {
+		Log.Error("registration failed: ", err)
+		return r, nil
+	}
+	Log.Infof("registered %s of type %s", req.Uuid, req.Type)
 	r.Enrolled = true
 	return r, nil
 }
+
+func (s *statisticsServer) PushMetrics(stream pb.Statistics_PushMetricsServer) error {
+	r := &pb.PushRes{}
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(r)
+		}
+		if err != nil {
+			return err
+		}
+		err = common.CheckIdentity(stream.Context(), batch.Uuid,
+			s.matchName)
+		if err != nil {
+			Log.Error("push refused: ", err)
+			return err
+		}
+
+		accepted, rejected, err := s.aggregator.push(batch)
+		if err != nil {
+			Log.Error("push failed: ", err)
+			return err
+		}
+		Log.Debugf("%s pushed %d metrics, %d rejected", batch.Uuid,
+			accepted+rejected, rejected)
+		r.Accepted += accepted
+		r.Rejected += rejected
+	}
+}
+
+func (s *statisticsServer) QueryRange(ctx context.Context, req *pb.QueryRangeReq) (res *pb.QueryRangeRes, err error) {
+	var from, to time.Time
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+	var step time.Duration
+	if req.Step != nil {
+		step = req.Step.AsDuration()
+	}
+
+	ranges, step, err := s.aggregator.query(req.Uuid, req.Metric,
+		req.Labels, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &pb.QueryRangeRes{Step: durationpb.New(step)}
+	for _, sr := range ranges {
+		series := &pb.Series{Labels: sr.labels, Type: sr.mType}
+		for _, p := range sr.points {
+			series.Points = append(series.Points, &pb.Point{
+				Timestamp: timestamppb.New(p.ts),
+				Value:     p.value,
+				Count:     p.count,
+				Sum:       p.sum,
+			})
+		}
+		r.Series = append(r.Series, series)
+	}
+	return r, nil
+}
@@ -2,15 +2,216 @@ package statistics
 
 import (
 	"context"
+	"github.com/ramdrjn/serverbox/pkgs/common"
 	pb "github.com/ramdrjn/serverbox/pkgs/statistics/pkgs/sb_stats_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
-var s = &statisticsServer{}
+var s *statisticsServer
+
+func init() {
+	var err error
+	Log = common.InitializeLogger("statistics", common.DebugLevel)
+	s, err = newStatisticsServer(statisticsConf{})
+	if err != nil {
+		panic(err)
+	}
+}
 
 func TestRegisterForStats(t *testing.T) {
 	req := &pb.RegisterReq{}
 	req.Uuid = "test"
 	req.Type = pb.RegisterReq_SERVER
-	s.RegisterForStats(context.TODO(), req)
+	res, err := s.RegisterForStats(context.TODO(), req)
+	if err != nil || !res.Enrolled {
+		t.Errorf("registration not enrolled: %v", err)
+	}
+}
+
+type pushStream struct {
+	grpc.ServerStream
+	batches []*pb.MetricBatch
+	res     *pb.PushRes
+}
+
+func (p *pushStream) Context() context.Context {
+	return context.TODO()
+}
+
+func (p *pushStream) Recv() (*pb.MetricBatch, error) {
+	if len(p.batches) == 0 {
+		return nil, io.EOF
+	}
+	b := p.batches[0]
+	p.batches = p.batches[1:]
+	return b, nil
+}
+
+func (p *pushStream) SendAndClose(res *pb.PushRes) error {
+	p.res = res
+	return nil
+}
+
+func TestPushMetrics(t *testing.T) {
+	s, err := newStatisticsServer(statisticsConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RegisterForStats(context.TODO(),
+		&pb.RegisterReq{Uuid: "test", Type: pb.RegisterReq_SERVER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"route": "/"}
+	batch := &pb.MetricBatch{Uuid: "test", Metrics: []*pb.Metric{
+		{Name: "requests_total", Type: pb.Metric_COUNTER,
+			Labels: labels, Value: 2},
+		{Name: "in_flight", Type: pb.Metric_GAUGE, Value: 3},
+		{Name: "latency_seconds", Type: pb.Metric_HISTOGRAM,
+			Bounds: []float64{0.1, 1}, BucketCounts: []uint64{1, 0, 1},
+			Count: 2, Sum: 2.05},
+		{Name: "bad name", Type: pb.Metric_GAUGE},
+	}}
+	stream := &pushStream{batches: []*pb.MetricBatch{batch, batch}}
+	err = s.PushMetrics(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.res.Accepted != 6 || stream.res.Rejected != 2 {
+		t.Errorf("unexpected push result %v", stream.res)
+	}
+
+	src := s.aggregator.sources["test"]
+	counter := src.series[seriesKey("requests_total", labels)]
+	if counter == nil || counter.value != 4 {
+		t.Errorf("unexpected counter %+v", counter)
+	}
+	gauge := src.series[seriesKey("in_flight", nil)]
+	if gauge == nil || gauge.value != 3 {
+		t.Errorf("unexpected gauge %+v", gauge)
+	}
+	hist := src.series[seriesKey("latency_seconds", nil)]
+	if hist == nil || hist.count != 4 || hist.buckets[2] != 2 {
+		t.Errorf("unexpected histogram %+v", hist)
+	}
+
+	mismatch := &pb.MetricBatch{Uuid: "test", Metrics: []*pb.Metric{
+		{Name: "requests_total", Type: pb.Metric_GAUGE, Labels: labels},
+		{Name: "latency_seconds", Type: pb.Metric_HISTOGRAM,
+			Bounds: []float64{1}, BucketCounts: []uint64{1, 0}},
+	}}
+	stream = &pushStream{batches: []*pb.MetricBatch{mismatch}}
+	err = s.PushMetrics(stream)
+	if err != nil || stream.res.Rejected != 2 {
+		t.Errorf("expected type and bounds mismatch to be rejected")
+	}
+}
+
+func TestPushMetricsUnregistered(t *testing.T) {
+	stream := &pushStream{batches: []*pb.MetricBatch{{Uuid: "unknown"}}}
+	err := s.PushMetrics(stream)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	a := newAggregator(defaultTiers)
+	a.register("web@a:1", pb.RegisterReq_SERVER)
+	_, _, err := a.push(&pb.MetricBatch{Uuid: "web@a:1", Metrics: []*pb.Metric{
+		{Name: "requests_total", Type: pb.Metric_COUNTER, Help: "Requests.",
+			Labels: map[string]string{"code": "2xx"}, Value: 3},
+		{Name: "latency_seconds", Type: pb.Metric_HISTOGRAM,
+			Bounds: []float64{0.1, 1}, BucketCounts: []uint64{1, 2, 1},
+			Count: 4, Sum: 3.5},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	prometheusHandler(a).ServeHTTP(rec, httptest.NewRequest("GET",
+		"/metrics", nil))
+
+	expected := `# TYPE latency_seconds histogram
+latency_seconds_bucket{uuid="web@a:1",le="0.1"} 1
+latency_seconds_bucket{uuid="web@a:1",le="1"} 3
+latency_seconds_bucket{uuid="web@a:1",le="+Inf"} 4
+latency_seconds_sum{uuid="web@a:1"} 3.5
+latency_seconds_count{uuid="web@a:1"} 4
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{uuid="web@a:1",code="2xx"} 3
+`
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s", rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s",
+			rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	tiers, err := newTiers([]retentionConf{{Resolution: 5, Duration: 60},
+		{Resolution: 1, Duration: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	now := start
+	a := newAggregator(tiers)
+	a.now = func() time.Time { return now }
+	a.register("web@a:1", pb.RegisterReq_SERVER)
+
+	labels := map[string]string{"route": "/", "code": "2xx"}
+	for i := 0; i < 30; i++ {
+		_, _, err = a.push(&pb.MetricBatch{Uuid: "web@a:1",
+			Metrics: []*pb.Metric{{Name: "requests_total",
+				Type: pb.Metric_COUNTER, Labels: labels, Value: 1}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	//the last 5 seconds are still within the 1s tier
+	ranges, step, err := a.query("web@a:1", "requests_total",
+		map[string]string{"route": "/"}, now.Add(-5*time.Second), now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != time.Second || len(ranges) != 1 ||
+		len(ranges[0].points) != 5 {
+		t.Fatalf("unexpected fine range, step %s: %+v", step, ranges)
+	}
+	if ranges[0].points[4].value != 30 {
+		t.Errorf("expected total of 30, got %v", ranges[0].points[4])
+	}
+
+	//the whole range is only held by the 5s tier
+	ranges, step, err = a.query("web@a:1", "requests_total", nil, start,
+		now, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != 10*time.Second || len(ranges[0].points) != 3 {
+		t.Fatalf("unexpected coarse range, step %s: %+v", step, ranges)
+	}
+	if ranges[0].points[0].value != 10 {
+		t.Errorf("expected total of 10, got %v", ranges[0].points[0])
+	}
+
+	ranges, _, err = a.query("web@a:1", "requests_total",
+		map[string]string{"route": "/other"}, start, now, 0)
+	if err != nil || len(ranges) != 0 {
+		t.Errorf("expected no series for other route, got %v", ranges)
+	}
 }
@@ -0,0 +1,142 @@
+package statistics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// tier is one downsampled resolution of the series history, kept for
+// retention.
+type tier struct {
+	resolution time.Duration
+	retention  time.Duration
+}
+
+var defaultTiers = []tier{
+	{resolution: 10 * time.Second, retention: time.Hour},
+	{resolution: time.Minute, retention: 24 * time.Hour},
+}
+
+func newTiers(confs []retentionConf) ([]tier, error) {
+	if len(confs) == 0 {
+		return defaultTiers, nil
+	}
+
+	tiers := make([]tier, 0, len(confs))
+	for _, conf := range confs {
+		if conf.Resolution == 0 || conf.Duration < conf.Resolution {
+			return nil, fmt.Errorf("invalid retention of %ds at %ds",
+				conf.Duration, conf.Resolution)
+		}
+		tiers = append(tiers, tier{
+			resolution: time.Duration(conf.Resolution) * time.Second,
+			retention:  time.Duration(conf.Duration) * time.Second,
+		})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].resolution < tiers[j].resolution
+	})
+	return tiers, nil
+}
+
+type point struct {
+	ts    time.Time
+	value float64
+	count uint64
+	sum   float64
+}
+
+// ring holds the points of one tier, oldest first. Each point is the
+// last value seen in its resolution interval.
+type ring struct {
+	resolution time.Duration
+	points     []point
+	start      int
+	count      int
+}
+
+func newRing(t tier) *ring {
+	return &ring{
+		resolution: t.resolution,
+		points:     make([]point, int(t.retention/t.resolution)),
+	}
+}
+
+func (r *ring) record(p point) {
+	p.ts = p.ts.Truncate(r.resolution)
+	size := len(r.points)
+	if r.count != 0 {
+		last := &r.points[(r.start+r.count-1)%size]
+		if !p.ts.After(last.ts) {
+			*last = point{last.ts, p.value, p.count, p.sum}
+			return
+		}
+	}
+	if r.count < size {
+		r.points[(r.start+r.count)%size] = p
+		r.count++
+		return
+	}
+	r.points[r.start] = p
+	r.start = (r.start + 1) % size
+}
+
+// between returns the points within [from, to] downsampled to step, each
+// stamped with the start of its step.
+func (r *ring) between(from time.Time, to time.Time, step time.Duration) []point {
+	var points []point
+	size := len(r.points)
+	for i := 0; i < r.count; i++ {
+		p := r.points[(r.start+i)%size]
+		if p.ts.Before(from) || p.ts.After(to) {
+			continue
+		}
+		p.ts = p.ts.Truncate(step)
+		if n := len(points); n != 0 && points[n-1].ts.Equal(p.ts) {
+			points[n-1] = p
+			continue
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+type timeseries struct {
+	tiers []tier
+	rings []*ring
+}
+
+func newTimeseries(tiers []tier) *timeseries {
+	ts := &timeseries{tiers: tiers}
+	for _, t := range tiers {
+		ts.rings = append(ts.rings, newRing(t))
+	}
+	return ts
+}
+
+func (ts *timeseries) record(p point) {
+	for _, r := range ts.rings {
+		r.record(p)
+	}
+}
+
+func (ts *timeseries) query(tier int, from time.Time, to time.Time, step time.Duration) []point {
+	return ts.rings[tier].between(from, to, step)
+}
+
+// pickTier returns the finest tier that still holds from, falling back to
+// the coarsest, and step raised to at least the resolution of that tier.
+func pickTier(tiers []tier, from time.Time, step time.Duration, now time.Time) (int, time.Duration) {
+	i := len(tiers) - 1
+	for j, t := range tiers {
+		if !from.Before(now.Add(-t.retention)) {
+			i = j
+			break
+		}
+	}
+	if step < tiers[i].resolution {
+		step = tiers[i].resolution
+	}
+	return i, step
+}
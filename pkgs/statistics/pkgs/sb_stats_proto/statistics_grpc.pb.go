@@ -19,6 +19,8 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StatisticsClient interface {
 	RegisterForStats(ctx context.Context, in *RegisterReq, opts ...grpc.CallOption) (*RegisterRes, error)
+	PushMetrics(ctx context.Context, opts ...grpc.CallOption) (Statistics_PushMetricsClient, error)
+	QueryRange(ctx context.Context, in *QueryRangeReq, opts ...grpc.CallOption) (*QueryRangeRes, error)
 }
 
 type statisticsClient struct {
@@ -38,11 +40,56 @@ func (c *statisticsClient) RegisterForStats(ctx context.Context, in *RegisterReq
 	return out, nil
 }
 
+func (c *statisticsClient) PushMetrics(ctx context.Context, opts ...grpc.CallOption) (Statistics_PushMetricsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Statistics_ServiceDesc.Streams[0], "/sb_stats_proto.Statistics/PushMetrics", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &statisticsPushMetricsClient{stream}
+	return x, nil
+}
+
+type Statistics_PushMetricsClient interface {
+	Send(*MetricBatch) error
+	CloseAndRecv() (*PushRes, error)
+	grpc.ClientStream
+}
+
+type statisticsPushMetricsClient struct {
+	grpc.ClientStream
+}
+
+func (x *statisticsPushMetricsClient) Send(m *MetricBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *statisticsPushMetricsClient) CloseAndRecv() (*PushRes, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PushRes)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *statisticsClient) QueryRange(ctx context.Context, in *QueryRangeReq, opts ...grpc.CallOption) (*QueryRangeRes, error) {
+	out := new(QueryRangeRes)
+	err := c.cc.Invoke(ctx, "/sb_stats_proto.Statistics/QueryRange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StatisticsServer is the server API for Statistics service.
 // All implementations must embed UnimplementedStatisticsServer
 // for forward compatibility
 type StatisticsServer interface {
 	RegisterForStats(context.Context, *RegisterReq) (*RegisterRes, error)
+	PushMetrics(Statistics_PushMetricsServer) error
+	QueryRange(context.Context, *QueryRangeReq) (*QueryRangeRes, error)
 	mustEmbedUnimplementedStatisticsServer()
 }
 
@@ -53,6 +100,12 @@ type UnimplementedStatisticsServer struct {
 func (UnimplementedStatisticsServer) RegisterForStats(context.Context, *RegisterReq) (*RegisterRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RegisterForStats not implemented")
 }
+func (UnimplementedStatisticsServer) PushMetrics(Statistics_PushMetricsServer) error {
+	return status.Errorf(codes.Unimplemented, "method PushMetrics not implemented")
+}
+func (UnimplementedStatisticsServer) QueryRange(context.Context, *QueryRangeReq) (*QueryRangeRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryRange not implemented")
+}
 func (UnimplementedStatisticsServer) mustEmbedUnimplementedStatisticsServer() {}
 
 // UnsafeStatisticsServer may be embedded to opt out of forward compatibility for this service.
@@ -84,6 +137,50 @@ func _Statistics_RegisterForStats_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Statistics_PushMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StatisticsServer).PushMetrics(&statisticsPushMetricsServer{stream})
+}
+
+type Statistics_PushMetricsServer interface {
+	SendAndClose(*PushRes) error
+	Recv() (*MetricBatch, error)
+	grpc.ServerStream
+}
+
+type statisticsPushMetricsServer struct {
+	grpc.ServerStream
+}
+
+func (x *statisticsPushMetricsServer) SendAndClose(m *PushRes) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *statisticsPushMetricsServer) Recv() (*MetricBatch, error) {
+	m := new(MetricBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Statistics_QueryRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRangeReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatisticsServer).QueryRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sb_stats_proto.Statistics/QueryRange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatisticsServer).QueryRange(ctx, req.(*QueryRangeReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Statistics_ServiceDesc is the grpc.ServiceDesc for Statistics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -95,7 +192,17 @@ var Statistics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterForStats",
 			Handler:    _Statistics_RegisterForStats_Handler,
 		},
+		{
+			MethodName: "QueryRange",
+			Handler:    _Statistics_QueryRange_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PushMetrics",
+			Handler:       _Statistics_PushMetrics_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "statistics.proto",
 }
@@ -30,12 +30,24 @@ func Initialize(debug bool, confFilePath string) error {
 		return err
 	}
 
+	err = InitializeStatisticsServer(&statscontext)
+	if err != nil {
+		return err
+	}
+
+	err = InitializePrometheus(&statscontext)
+	if err != nil {
+		return err
+	}
+
 	err = InitializeGrpcServer(&statscontext)
 	if err != nil {
+		ShutDownPrometheus(&statscontext)
 		return err
 	}
 
 	ShutDownGrpcServer(&statscontext)
+	ShutDownPrometheus(&statscontext)
 
 	return nil
 }
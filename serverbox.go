@@ -2,9 +2,34 @@ package serverbox
 
 import (
 	"fmt"
-	. "github.com/ramdrjn/serverbox/internal"
+	sbi "github.com/ramdrjn/serverbox/internal"
 	"github.com/ramdrjn/serverbox/pkgs/common"
 	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"google.golang.org/grpc"
+)
+
+// The internal types a program using serverbox needs to name.
+type (
+	SbContext      = sbi.SbContext
+	Server         = sbi.Server
+	ServerInstance = sbi.ServerInstance
+	ServerFactory  = sbi.ServerFactory
+	RawConf        = sbi.RawConf
+	Statistics     = sbi.Statistics
+	State          = sbi.State
+	ConnHandler    = sbi.ConnHandler
+	PacketHandler  = sbi.PacketHandler
+	ServersError   = sbi.ServersError
+	ServerError    = sbi.ServerError
+	Status         = sbi.Status
+)
+
+const (
+	StatusStopped  = sbi.StatusStopped
+	StatusStarting = sbi.StatusStarting
+	StatusRunning  = sbi.StatusRunning
+	StatusStopping = sbi.StatusStopping
+	StatusFailed   = sbi.StatusFailed
 )
 
 func Initialize(debug bool, confFilePath string) (sbcontext *SbContext, err error) {
@@ -19,37 +44,84 @@ func Initialize(debug bool, confFilePath string) (sbcontext *SbContext, err erro
 	sbcontext.Log = common.InitializeLogger("serverbox", logLevel)
 
 	//Update internal log so that its accessible everwhere
-	Log = sbcontext.Log
+	sbi.Log = sbcontext.Log
 
 	sbcontext.Log.Debug("server box logging initialized")
 
-	err = common.ProcessConfFile(confFilePath, &sbcontext.Conf)
+	sbcontext.ConfPath = confFilePath
+	sbcontext.ConfMeta, err = common.DecodeConfFile(confFilePath,
+		&sbcontext.Conf)
 	if err != nil {
 		sbcontext.Log.Error("configuration file %s failed: ",
 			confFilePath, err)
 		return nil, err
 	}
 
-	err = InitializeServers(sbcontext)
+	err = sbi.InitializeServers(sbcontext)
 
 	return sbcontext, err
 }
 
 func Run(sbcontext *SbContext) (err error) {
-	err = RunServers(sbcontext)
+	err = sbi.RunServers(sbcontext)
 	return err
 }
 
 func ShutDown(sbcontext *SbContext) (err error) {
-	err = ShutDownServers(sbcontext)
+	err = sbi.ShutDownServers(sbcontext)
 	return err
 }
 
 func Abort(sbcontext *SbContext) (err error) {
-	err = AbortServers(sbcontext)
+	err = sbi.AbortServers(sbcontext)
+	return err
+}
+
+// Reload applies the changes made to the configuration file since it was
+// read: added servers are started, removed ones shut down and changed ones
+// restarted, keeping their attached routers and handlers.
+func Reload(sbcontext *SbContext) (err error) {
+	err = sbi.ReloadServers(sbcontext)
 	return err
 }
 
+// StartServer runs a stopped server and waits for it to bind.
+func StartServer(serName string, sbc *SbContext) error {
+	return sbi.StartServer(serName, sbc)
+}
+
+// StopServer shuts a server down, draining it when graceful. Its
+// statistics and state links stay up for it to be started again.
+func StopServer(serName string, graceful bool, sbc *SbContext) error {
+	return sbi.StopServer(serName, graceful, sbc)
+}
+
+func RestartServer(serName string, sbc *SbContext) error {
+	return sbi.RestartServer(serName, sbc)
+}
+
+func ServerStatus(serName string, sbc *SbContext) (Status, error) {
+	return sbi.ServerStatus(serName, sbc)
+}
+
 func AttachRouter(router mux.Router, serName string, sbc *SbContext) error {
-	return AttachRouterToServer(router, serName, sbc)
+	return sbi.AttachRouterToServer(router, serName, sbc)
+}
+
+func AttachGrpcService(desc *grpc.ServiceDesc, impl interface{}, serName string, sbc *SbContext) error {
+	return sbi.AttachGrpcServiceToServer(desc, impl, serName, sbc)
+}
+
+func AttachConnHandler(handler ConnHandler, serName string, sbc *SbContext) error {
+	return sbi.AttachConnHandlerToServer(handler, serName, sbc)
+}
+
+func AttachPacketHandler(handler PacketHandler, serName string, sbc *SbContext) error {
+	return sbi.AttachPacketHandlerToServer(handler, serName, sbc)
+}
+
+// RegisterServerType adds a server type for the configuration to use, it
+// has to be called before Initialize.
+func RegisterServerType(name string, factory ServerFactory) error {
+	return sbi.RegisterServerType(name, factory)
 }
@@ -1,9 +1,31 @@
 package serverbox
 
 import (
-	. "github.com/ramdrjn/serverbox/internal"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/ramdrjn/serverbox/pkgs/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var sbcontext *SbContext
@@ -16,12 +38,31 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func testRouteHandler(args *mux.HandlerArgs) {
+	fmt.Fprint(args.HttpRes, args.UserData)
+}
+
 func TestRouteAttach(t *testing.T) {
 	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "test-DONE")
 	err := AttachRouter(r, "web", sbcontext)
 	if err != nil {
 		t.Error(err)
 	}
+	err = AttachRouter(r, "local", sbcontext)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) ServeConn(ctx context.Context, conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+func (echoHandler) ServePacket(ctx context.Context, conn net.PacketConn, addr net.Addr, packet []byte) {
+	conn.WriteTo(packet, addr)
 }
 
 func TestRun(t *testing.T) {
@@ -31,9 +72,1499 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRequest(t *testing.T) {
+	var res *http.Response
+	var err error
+	for i := 0; i < 10; i++ {
+		res, err = http.Get("http://localhost:8080/test")
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "test-DONE" {
+		t.Errorf("unexpected response %q", body)
+	}
+}
+
+func TestUnixRequest(t *testing.T) {
+	dir := t.TempDir()
+	sock, echoSock := filepath.Join(dir, "local.sock"),
+		filepath.Join(dir, "echo.sock")
+	//a socket file left behind by a server that is gone
+	stale, err := net.Listen("unix", echoSock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	sbc := initialize(t, fmt.Sprintf(`
+[servers.local]
+  bind_unix = %q
+  unix_mode = "0660"
+  type = "http"
+
+[servers.echo]
+  bind_unix = %q
+  type = "tcp"
+`, sock, echoSock))
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "unix-DONE")
+	err = AttachRouter(r, "local", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AttachConnHandler(echoHandler{}, "echo", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uuid := sbc.Servers["local"].Uuid(); uuid != "local@unix:"+sock {
+		t.Errorf("unexpected uuid %s", uuid)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}}}
+	res, err := client.Get("http://local/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "unix-DONE" {
+		t.Errorf("unexpected response %q", body)
+	}
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0660 {
+		t.Errorf("unexpected socket mode %s", fi.Mode())
+	}
+	echo(t, "unix", echoSock)
+}
+
+// initialize writes conf to a temporary file and initializes from it,
+// shutting the servers down at the end of the test.
+func initialize(t *testing.T, conf string) *SbContext {
+	file := filepath.Join(t.TempDir(), "serverbox.conf")
+	err := os.WriteFile(file, []byte(conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ShutDown(sbc) })
+	return sbc
+}
+
+func grpcConf(t *testing.T) (string, int) {
+	port := freePort(t)
+	return fmt.Sprintf(`
+[servers.grpc]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "grpc"
+
+[servers.web]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+`, port, freePort(t)), port
+}
+
+func TestGrpcServiceAttach(t *testing.T) {
+	conf, _ := grpcConf(t)
+	sbc := initialize(t, conf)
+	err := AttachGrpcService(&healthpb.Health_ServiceDesc, health.NewServer(),
+		"grpc", sbc)
+	if err != nil {
+		t.Error(err)
+	}
+	err = AttachGrpcService(&healthpb.Health_ServiceDesc, health.NewServer(),
+		"web", sbc)
+	if err == nil {
+		t.Error("attached a grpc service to an http server")
+	}
+}
+
+func TestGrpcRequest(t *testing.T) {
+	conf, port := grpcConf(t)
+	sbc := initialize(t, conf)
+	err := AttachGrpcService(&healthpb.Health_ServiceDesc, health.NewServer(),
+		"grpc", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := healthpb.NewHealthClient(conn).Check(ctx,
+		&healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected status %s", res.Status)
+	}
+}
+
+// echo retries, as a datagram sent before the server is bound is lost.
+func echo(t *testing.T, network string, address string) {
+	var reply string
+	var err error
+	for i := 0; i < 10 && reply == ""; i++ {
+		reply, err = exchange(network, address, "ping")
+		if err != nil {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "ping" {
+		t.Errorf("unexpected echo %q", reply)
+	}
+}
+
+func exchange(network string, address string, msg string) (string, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = conn.Write([]byte(msg))
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func freePacketPort(t *testing.T) int {
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// socketServer is the table of a tcp or udp server with the given socket
+// configurations.
+func socketServer(name string, kind string, port int, socket string) string {
+	return fmt.Sprintf(`
+[servers.%[1]s]
+  bind_ip = "localhost"
+  bind_port = %[3]d
+  type = %[2]q
+  drain_timeout = 10
+
+  [servers.%[1]s.configurations.socket]
+%[4]s
+`, name, kind, port, socket)
+}
+
+// gateHandler echoes, holding each connection or datagram until release
+// is closed. Datagrams other than "hold" are echoed right away.
+type gateHandler struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGateHandler() gateHandler {
+	return gateHandler{entered: make(chan struct{}, 10),
+		release: make(chan struct{})}
+}
+
+func (g gateHandler) ServeConn(ctx context.Context, conn net.Conn) {
+	g.entered <- struct{}{}
+	<-g.release
+	io.Copy(conn, conn)
+}
+
+func (g gateHandler) ServePacket(ctx context.Context, conn net.PacketConn, addr net.Addr, packet []byte) {
+	if string(packet) == "hold" {
+		g.entered <- struct{}{}
+		<-g.release
+	}
+	conn.WriteTo(packet, addr)
+}
+
+func TestSocketHandlerAttach(t *testing.T) {
+	sbc := initialize(t, socketServer("echo", "tcp", freePort(t), "")+
+		socketServer("echo_udp", "udp", freePacketPort(t), ""))
+	err := AttachConnHandler(echoHandler{}, "echo", sbc)
+	if err != nil {
+		t.Error(err)
+	}
+	err = AttachPacketHandler(echoHandler{}, "echo_udp", sbc)
+	if err != nil {
+		t.Error(err)
+	}
+	err = AttachConnHandler(echoHandler{}, "echo_udp", sbc)
+	if err == nil {
+		t.Error("attached a connection handler to a udp server")
+	}
+	err = AttachRouter(mux.NewRouter(), "echo", sbc)
+	if err == nil {
+		t.Error("attached a router to a tcp server")
+	}
+}
+
+func TestTcpRequest(t *testing.T) {
+	port := freePort(t)
+	sbc := initialize(t, socketServer("echo", "tcp", port, ""))
+	err := AttachConnHandler(echoHandler{}, "echo", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, "tcp", fmt.Sprintf("localhost:%d", port))
+}
+
+func TestUdpRequest(t *testing.T) {
+	port := freePacketPort(t)
+	sbc := initialize(t, socketServer("echo_udp", "udp", port, ""))
+	err := AttachPacketHandler(echoHandler{}, "echo_udp", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, "udp", fmt.Sprintf("localhost:%d", port))
+}
+
+func TestSocketMaxConnections(t *testing.T) {
+	tcpPort, udpPort := freePort(t), freePacketPort(t)
+	limit := "    max_connections = 1"
+	sbc := initialize(t, socketServer("echo", "tcp", tcpPort, limit)+
+		socketServer("echo_udp", "udp", udpPort, limit))
+	gate := newGateHandler()
+	err := AttachConnHandler(gate, "echo", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AttachPacketHandler(gate, "echo_udp", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcpAddress := fmt.Sprintf("localhost:%d", tcpPort)
+	held, err := net.Dial("tcp", tcpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	<-gate.entered
+	_, err = exchange("tcp", tcpAddress, "refused")
+	if err == nil {
+		t.Error("connection beyond max_connections served")
+	}
+
+	udpAddress := fmt.Sprintf("localhost:%d", udpPort)
+	holder, err := net.Dial("udp", udpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	_, err = holder.Write([]byte("hold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gate.entered
+	_, err = exchange("udp", udpAddress, "dropped")
+	if err == nil {
+		t.Error("datagram beyond max_connections served")
+	}
+
+	//the slots free up once the held ones are done
+	close(gate.release)
+	held.Close()
+	echo(t, "tcp", tcpAddress)
+	echo(t, "udp", udpAddress)
+}
+
+// floodHandler writes until a write fails and hands back the error.
+type floodHandler chan error
+
+func (f floodHandler) ServeConn(ctx context.Context, conn net.Conn) {
+	buf := make([]byte, 1<<16)
+	for {
+		_, err := conn.Write(buf)
+		if err != nil {
+			f <- err
+			return
+		}
+	}
+}
+
+func TestTcpDeadlines(t *testing.T) {
+	reader, writer := freePort(t), freePort(t)
+	sbc := initialize(t,
+		socketServer("reader", "tcp", reader, "    read_timeout = 1")+
+			socketServer("writer", "tcp", writer,
+				"    write_timeout = 1"))
+	err := AttachConnHandler(echoHandler{}, "reader", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flood := make(floodHandler, 1)
+	err = AttachConnHandler(flood, "writer", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//an idle connection is closed once the read deadline passes
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", reader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected the idle connection closed, got %v", err)
+	}
+
+	//a client that does not read makes the writes time out
+	conn, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", writer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err = <-flood:
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("expected a write timeout, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("write did not time out")
+	}
+}
+
+func TestSocketDrain(t *testing.T) {
+	tcpPort, udpPort := freePort(t), freePacketPort(t)
+	sbc := initialize(t, socketServer("echo", "tcp", tcpPort, "")+
+		socketServer("echo_udp", "udp", udpPort, ""))
+	gate := newGateHandler()
+	err := AttachConnHandler(gate, "echo", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AttachPacketHandler(gate, "echo_udp", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcpAddress := fmt.Sprintf("localhost:%d", tcpPort)
+	conn, err := net.Dial("tcp", tcpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	packetConn, err := net.Dial("udp", fmt.Sprintf("localhost:%d", udpPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packetConn.Close()
+	_, err = packetConn.Write([]byte("hold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gate.entered
+	<-gate.entered
+
+	stopped := make(chan error, 2)
+	for _, name := range []string{"echo", "echo_udp"} {
+		go func(name string) {
+			stopped <- StopServer(name, true, sbc)
+		}(name)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, name := range []string{"echo", "echo_udp"} {
+		status, _ := ServerStatus(name, sbc)
+		if status != StatusStopping {
+			t.Errorf("unexpected status %s of %s while draining",
+				status, name)
+		}
+	}
+	_, err = exchange("tcp", tcpAddress, "new")
+	if err == nil {
+		t.Error("new connection served while draining")
+	}
+
+	//the connection and datagram already in are still served
+	close(gate.release)
+	buf := make([]byte, 4)
+	packetConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(packetConn, buf)
+	if err != nil || string(buf) != "hold" {
+		t.Errorf("held datagram answered %q %v", buf, err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("open"))
+	if err == nil {
+		_, err = io.ReadFull(conn, buf)
+	}
+	if err != nil || string(buf) != "open" {
+		t.Errorf("open connection answered %q %v", buf, err)
+	}
+	conn.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-stopped:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("drain did not end with the open connection")
+		}
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	port := freePort(t)
+	sbc := initialize(t, fmt.Sprintf(`
+[servers.web]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+`, port))
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "lifecycle-DONE")
+	err := AttachRouter(r, "web", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:%d/test", port)
+	answers := func(what string) {
+		body, err := get(url)
+		if err != nil || body != "lifecycle-DONE" {
+			t.Errorf("%s server answered %q %v", what, body, err)
+		}
+	}
+
+	status, _ := ServerStatus("web", sbc)
+	if status != StatusStopped {
+		t.Errorf("unexpected status %s before Run", status)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = ServerStatus("web", sbc)
+	if err != nil || status != StatusRunning {
+		t.Fatalf("unexpected status %s %v", status, err)
+	}
+	err = StartServer("web", sbc)
+	if err == nil {
+		t.Error("started a running server")
+	}
+
+	err = StopServer("web", true, sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ = ServerStatus("web", sbc)
+	if status != StatusStopped {
+		t.Errorf("unexpected status %s", status)
+	}
+	_, err = get(url)
+	if err == nil {
+		t.Error("stopped server answered")
+	}
+	for _, graceful := range []bool{true, false} {
+		err = StopServer("web", graceful, sbc)
+		if err == nil {
+			t.Errorf("stopped a stopped server, graceful %v", graceful)
+		}
+	}
+
+	err = StartServer("web", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers("started")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := RestartServer("web", sbc)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	answers("restarted")
+
+	//restarting a stopped server starts it
+	err = StopServer("web", false, sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RestartServer("web", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ = ServerStatus("web", sbc)
+	if status != StatusRunning {
+		t.Errorf("unexpected status %s after restart", status)
+	}
+	answers("restarted")
+
+	_, err = ServerStatus("missing", sbc)
+	if err == nil {
+		t.Error("status of a server that does not exist")
+	}
+	for _, op := range []func(string, *SbContext) error{StartServer,
+		RestartServer} {
+		if op("missing", sbc) == nil {
+			t.Error("started a server that does not exist")
+		}
+	}
+	if StopServer("missing", true, sbc) == nil {
+		t.Error("stopped a server that does not exist")
+	}
+}
+
 func TestShutDown(t *testing.T) {
 	err := ShutDown(sbcontext)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+type customConf struct {
+	Custom struct {
+		Greeting string
+	}
+}
+
+type customServer struct {
+	server      *Server
+	conf        customConf
+	initialized bool
+}
+
+func (c *customServer) InitializeServerInstance() error {
+	c.initialized = true
+	return nil
+}
+
+func (c *customServer) RunServerInstance() error      { return nil }
+func (c *customServer) ShutDownServerInstance() error { return nil }
+func (c *customServer) AbortServerInstance() error    { return nil }
+
+func (c *customServer) AttachRouterServerInstance(router mux.Router) error {
+	return nil
+}
+
+func TestRegisterServerType(t *testing.T) {
+	var custom *customServer
+	factory := func(server *Server, conf RawConf) (ServerInstance, error) {
+		custom = &customServer{server: server}
+		return custom, conf.Decode(&custom.conf)
+	}
+	err := RegisterServerType("custom", factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterServerType("http", factory)
+	if err == nil {
+		t.Error("registered a server type twice")
+	}
+
+	conf := filepath.Join(t.TempDir(), "custom.conf")
+	err = os.WriteFile(conf, []byte(`
+[servers.box]
+  bind_ip = "localhost"
+  bind_port = 8099
+  type = "custom"
+
+  [servers.box.configurations.custom]
+    greeting = "hello"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if custom == nil || !custom.initialized {
+		t.Fatal("custom server not initialized")
+	}
+	if custom.conf.Custom.Greeting != "hello" {
+		t.Errorf("unexpected configuration %+v", custom.conf)
+	}
+	if custom.server.Uuid() != "box@localhost:8099" {
+		t.Errorf("unexpected uuid %s", custom.server.Uuid())
+	}
+}
+
+// stubServer binds if its configuration says so, and runs until told to
+// fail or stopped.
+type stubServer struct {
+	conf struct {
+		Stub struct {
+			Bind bool
+		}
+	}
+	server  *Server
+	fail    chan error
+	stopped chan struct{}
+	once    sync.Once
+}
+
+var stubs = make(chan *stubServer, 10)
+
+func init() {
+	err := RegisterServerType("stub", func(server *Server, conf RawConf) (ServerInstance, error) {
+		s := &stubServer{server: server, fail: make(chan error, 1),
+			stopped: make(chan struct{})}
+		stubs <- s
+		return s, conf.Decode(&s.conf)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (s *stubServer) InitializeServerInstance() error { return nil }
+
+func (s *stubServer) RunServerInstance() error {
+	if s.conf.Stub.Bind {
+		s.server.Bound()
+	}
+	select {
+	case err := <-s.fail:
+		return err
+	case <-s.stopped:
+		return nil
+	}
+}
+
+func (s *stubServer) ShutDownServerInstance() error {
+	s.once.Do(func() { close(s.stopped) })
+	return nil
+}
+
+func (s *stubServer) AbortServerInstance() error {
+	return s.ShutDownServerInstance()
+}
+
+func (s *stubServer) AttachRouterServerInstance(router mux.Router) error {
+	return nil
+}
+
+func TestStartTimeout(t *testing.T) {
+	sbc := initialize(t, `
+[servers.unbound]
+  bind_ip = "localhost"
+  bind_port = 1
+  type = "stub"
+  start_timeout = 1
+`)
+	stub := <-stubs
+	err := Run(sbc)
+	errs, ok := err.(ServersError)
+	if !ok || errs["unbound"] == nil {
+		t.Fatalf("expected the unbound server to fail, got %v", err)
+	}
+	status, _ := ServerStatus("unbound", sbc)
+	if status != StatusFailed {
+		t.Errorf("unexpected status %s", status)
+	}
+	select {
+	case <-stub.stopped:
+	default:
+		t.Error("server left running after the start timeout")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	sbc := initialize(t, `
+[servers.failing]
+  bind_ip = "localhost"
+  bind_port = 1
+  type = "stub"
+
+  [servers.failing.configurations.stub]
+    bind = true
+`)
+	stub := <-stubs
+	err := Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.fail <- errors.New("lost the socket")
+	select {
+	case err = <-sbc.Errors:
+		serr, ok := err.(*ServerError)
+		if !ok || serr.Server != "failing" {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error not reported")
+	}
+	status, _ := ServerStatus("failing", sbc)
+	if status != StatusFailed {
+		t.Errorf("unexpected status %s", status)
+	}
+}
+
+func TestRunPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	conf := filepath.Join(t.TempDir(), "taken.conf")
+	err = os.WriteFile(conf, []byte(fmt.Sprintf(`
+[servers.taken]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+`, port)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	errs, ok := err.(ServersError)
+	if !ok || errs["taken"] == nil {
+		t.Fatalf("expected the taken server to fail, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "taken: ") {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func get(url string) (string, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestReload(t *testing.T) {
+	keep, change, moved, gone, added := freePort(t), freePort(t),
+		freePort(t), freePort(t), freePort(t)
+	table := func(name string, port int) string {
+		return fmt.Sprintf(`
+[servers.%s]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+`, name, port)
+	}
+
+	conf := filepath.Join(t.TempDir(), "reload.conf")
+	err := os.WriteFile(conf, []byte(table("keep", keep)+
+		table("change", change)+table("gone", gone)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDown(sbc)
+
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "reload-DONE")
+	for _, name := range []string{"keep", "change"} {
+		err = AttachRouter(r, name, sbc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := sbc.Servers["keep"]
+
+	err = os.WriteFile(conf, []byte(table("keep", keep)+
+		table("change", moved)+table("added", added)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Reload(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sbc.Servers["keep"] != kept {
+		t.Error("unchanged server was restarted")
+	}
+	for _, port := range []int{keep, moved} {
+		body, err := get(fmt.Sprintf("http://localhost:%d/test", port))
+		if err != nil || body != "reload-DONE" {
+			t.Errorf("port %d answered %q %v", port, body, err)
+		}
+	}
+	for _, port := range []int{change, gone} {
+		_, err = get(fmt.Sprintf("http://localhost:%d/test", port))
+		if err == nil {
+			t.Errorf("port %d still answers", port)
+		}
+	}
+	status, err := ServerStatus("added", sbc)
+	if err != nil || status != StatusRunning {
+		t.Errorf("added server %s %v", status, err)
+	}
+	_, err = ServerStatus("gone", sbc)
+	if err == nil {
+		t.Error("removed server is still known")
+	}
+}
+
+func TestReloadKeeps(t *testing.T) {
+	port := freePort(t)
+	table := func(extra string) string {
+		return fmt.Sprintf(`
+[servers.web]
+  bind_ip = "localhost"
+  bind_port = %d
+  %s
+`, port, extra)
+	}
+	sbc := initialize(t, table(`type = "http"`))
+	conf := sbc.ConfPath
+	url := fmt.Sprintf("http://localhost:%d/test", port)
+
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "keep-DONE")
+	err := AttachRouter(r, "web", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := sbc.Servers["web"]
+
+	//a table that fails to initialize keeps the server running
+	err = os.WriteFile(conf, []byte(table(`type = "https"
+
+  [servers.web.configurations.http.tls]
+    cert = "/nonexistent/cert.pem"
+    key = "/nonexistent/key.pem"`)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Reload(sbc)
+	errs, ok := err.(ServersError)
+	if !ok || errs["web"] == nil {
+		t.Errorf("expected the web server to fail, got %v", err)
+	}
+	if sbc.Servers["web"] != kept {
+		t.Error("server replaced by one that did not initialize")
+	}
+	body, err := get(url)
+	if err != nil || body != "keep-DONE" {
+		t.Errorf("kept server answered %q %v", body, err)
+	}
+
+	//a stopped server is replaced but not started
+	err = StopServer("web", true, sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(conf, []byte(table("type = \"http\"\n  drain_timeout = 5")),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Reload(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ := ServerStatus("web", sbc)
+	if sbc.Servers["web"] == kept || status != StatusStopped {
+		t.Errorf("stopped server reloaded as %s", status)
+	}
+	err = StartServer("web", sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = get(url)
+	if err != nil || body != "keep-DONE" {
+		t.Errorf("started server answered %q %v", body, err)
+	}
+}
+
+func TestReloadSwap(t *testing.T) {
+	web, slow := freePort(t), freePort(t)
+	table := func(name string, port int, extra string) string {
+		return fmt.Sprintf(`
+[servers.%s]
+  bind_ip = "localhost"
+  bind_port = %d
+  %s
+`, name, port, extra)
+	}
+	stub := func(name string) string {
+		return fmt.Sprintf(`
+[servers.%[1]s]
+  bind_ip = "localhost"
+  bind_port = 1
+  type = "stub"
+
+  [servers.%[1]s.configurations.stub]
+    bind = true
+`, name)
+	}
+	sbc := initialize(t, table("web", web, `type = "http"`)+
+		table("slow", slow, "type = \"http\"\n  drain_timeout = 60"))
+	conf := sbc.ConfPath
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "swap-DONE")
+	r.RegisterRoute("/block", "get", func(args *mux.HandlerArgs) {
+		entered <- struct{}{}
+		<-release
+	}, nil)
+	for _, name := range []string{"web", "slow"} {
+		err := AttachRouter(r, name, sbc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := sbc.Servers["web"]
+
+	//a table that is not valid keeps the server running
+	err = os.WriteFile(conf, []byte(table("web", web, `type = "nope"`)+
+		table("slow", slow, "type = \"http\"\n  drain_timeout = 60")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Reload(sbc)
+	errs, ok := err.(ServersError)
+	if !ok || errs["web"] == nil || len(errs) != 1 {
+		t.Errorf("expected the web table to fail, got %v", err)
+	}
+	if sbc.Servers["web"] != kept {
+		t.Error("server replaced by a table that is not valid")
+	}
+	body, err := get(fmt.Sprintf("http://localhost:%d/test", web))
+	if err != nil || body != "swap-DONE" {
+		t.Errorf("kept server answered %q %v", body, err)
+	}
+
+	//the servers stay reachable while a replaced one drains, and the
+	//added ones report their errors
+	go get(fmt.Sprintf("http://localhost:%d/block", slow))
+	<-entered
+	err = os.WriteFile(conf, []byte(table("web", web, `type = "http"`)+
+		table("slow", slow, "type = \"http\"\n  drain_timeout = 59")+
+		stub("a1")+stub("a2")+stub("a3")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- Reload(sbc)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	queried := make(chan Status, 1)
+	go func() {
+		status, _ := ServerStatus("web", sbc)
+		queried <- status
+	}()
+	select {
+	case status := <-queried:
+		if status != StatusRunning {
+			t.Errorf("unexpected status %s while draining", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("servers locked while draining")
+	}
+	close(release)
+	select {
+	case err = <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("reload did not finish")
+	}
+	body, err = get(fmt.Sprintf("http://localhost:%d/test", slow))
+	if err != nil || body != "swap-DONE" {
+		t.Errorf("restarted server answered %q %v", body, err)
+	}
+
+	failed := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		stub := <-stubs
+		stub.fail <- errors.New("gone")
+		select {
+		case err = <-sbc.Errors:
+			failed[err.(*ServerError).Server] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("error not reported, got %v", failed)
+		}
+	}
+	if len(failed) != 3 {
+		t.Errorf("unexpected errors %v", failed)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	slow, stuck := freePort(t), freePort(t)
+	conf := filepath.Join(t.TempDir(), "drain.conf")
+	err := os.WriteFile(conf, []byte(fmt.Sprintf(`
+[servers.slow]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+  drain_timeout = 1
+
+[servers.stuck]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "http"
+  drain_timeout = 60
+`, slow, stuck)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDown(sbc)
+
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	r := mux.NewRouter()
+	r.RegisterRoute("/block", "get", func(args *mux.HandlerArgs) {
+		entered <- struct{}{}
+		<-release
+	}, nil)
+	for _, name := range []string{"slow", "stuck"} {
+		err = AttachRouter(r, name, sbc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []int{slow, stuck} {
+		go get(fmt.Sprintf("http://localhost:%d/block", port))
+		<-entered
+	}
+
+	start := time.Now()
+	err = StopServer("slow", true, sbc)
+	if err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("drain took %s", elapsed)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- StopServer("stuck", true, sbc)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	status, _ := ServerStatus("stuck", sbc)
+	if status != StatusStopping {
+		t.Errorf("unexpected status %s while draining", status)
+	}
+	err = StopServer("stuck", false, sbc)
+	if err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("abort did not cut the drain short")
+	}
+}
+
+type testCa struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCa(t *testing.T) *testCa {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCa{cert: cert, key: key, pool: pool}
+}
+
+// issue returns the pem encoded certificate and key of cn, valid for
+// localhost as a server and as a client.
+func (ca *testCa) issue(t *testing.T, cn string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert,
+		&key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+			Bytes: keyDer})
+}
+
+func (ca *testCa) clientCert(t *testing.T, cn string) tls.Certificate {
+	certPem, keyPem := ca.issue(t, cn)
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeKeyPair writes cert and key with a modification time that differs
+// from any earlier write, so that a reload sees the change.
+func writeKeyPair(t *testing.T, certFile string, keyFile string, certPem []byte, keyPem []byte) {
+	mtime := time.Now()
+	if fi, err := os.Stat(certFile); err == nil {
+		mtime = fi.ModTime().Add(time.Second)
+	}
+	for file, data := range map[string][]byte{certFile: certPem,
+		keyFile: keyPem} {
+		err := os.WriteFile(file, data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(file, mtime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tlsGet connects anew each time, so that every request goes through a
+// handshake.
+func tlsGet(url string, config *tls.Config) (string, *tls.ConnectionState, error) {
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   config,
+		DisableKeepAlives: true,
+	}}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), res.TLS, err
+}
+
+func TestHttps(t *testing.T) {
+	ca, other := newTestCa(t), newTestCa(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "secure.pem")
+	keyFile := filepath.Join(dir, "secure-key.pem")
+	certPem, keyPem := ca.issue(t, "first")
+	writeKeyPair(t, certFile, keyFile, certPem, keyPem)
+	caFile := filepath.Join(dir, "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secure, redirect, strict := freePort(t), freePort(t), freePort(t)
+	conf := filepath.Join(dir, "https.conf")
+	err = os.WriteFile(conf, []byte(fmt.Sprintf(`
+[servers.secure]
+  bind_ip = "localhost"
+  bind_port = %[1]d
+  type = "https"
+
+  [servers.secure.configurations.http.tls]
+    cert = %[4]q
+    key = %[5]q
+    reload_interval = 1
+    min_version = "1.2"
+    cipher_suites = ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"]
+    client_ca = %[6]q
+    client_auth = "verify_if_given"
+    redirect_port = %[2]d
+
+[servers.strict]
+  bind_ip = "localhost"
+  bind_port = %[3]d
+  type = "https"
+
+  [servers.strict.configurations.http.tls]
+    cert = %[4]q
+    key = %[5]q
+    min_version = "1.3"
+    client_ca = %[6]q
+`, secure, redirect, strict, certFile, keyFile, caFile)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDown(sbc)
+	r := mux.NewRouter()
+	r.RegisterRoute("/test", "get", testRouteHandler, "https-DONE")
+	for _, name := range []string{"secure", "strict"} {
+		err = AttachRouter(r, name, sbc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Run(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secureUrl := fmt.Sprintf("https://localhost:%d/test", secure)
+	tls12 := func() *tls.Config {
+		return &tls.Config{RootCAs: ca.pool, MaxVersion: tls.VersionTLS12}
+	}
+	body, state, err := tlsGet(secureUrl, tls12())
+	if err != nil || body != "https-DONE" {
+		t.Fatalf("answered %q %v", body, err)
+	}
+	if state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suite %s",
+			tls.CipherSuiteName(state.CipherSuite))
+	}
+	if cn := state.PeerCertificates[0].Subject.CommonName; cn != "first" {
+		t.Errorf("unexpected certificate %s", cn)
+	}
+
+	config := tls12()
+	config.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	_, _, err = tlsGet(secureUrl, config)
+	if err == nil {
+		t.Error("handshake with a cipher suite not configured")
+	}
+	config = tls12()
+	config.Certificates = []tls.Certificate{other.clientCert(t, "client")}
+	_, _, err = tlsGet(secureUrl, config)
+	if err == nil {
+		t.Error("accepted a client certificate of another ca")
+	}
+	config = tls12()
+	config.Certificates = []tls.Certificate{ca.clientCert(t, "client")}
+	_, _, err = tlsGet(secureUrl, config)
+	if err != nil {
+		t.Error(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(fmt.Sprintf("http://localhost:%d/test?q=1",
+		redirect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	location := fmt.Sprintf("https://localhost:%d/test?q=1", secure)
+	if res.StatusCode != http.StatusMovedPermanently ||
+		res.Header.Get("Location") != location {
+		t.Errorf("unexpected redirect %d to %s", res.StatusCode,
+			res.Header.Get("Location"))
+	}
+
+	strictUrl := fmt.Sprintf("https://localhost:%d/test", strict)
+	_, _, err = tlsGet(strictUrl, tls12())
+	if err == nil {
+		t.Error("handshake below min_version")
+	}
+	config = &tls.Config{RootCAs: ca.pool}
+	_, _, err = tlsGet(strictUrl, config)
+	if err == nil {
+		t.Error("accepted a client without a certificate")
+	}
+	config.Certificates = []tls.Certificate{ca.clientCert(t, "client")}
+	body, _, err = tlsGet(strictUrl, config)
+	if err != nil || body != "https-DONE" {
+		t.Errorf("answered %q %v", body, err)
+	}
+
+	certPem, keyPem = ca.issue(t, "second")
+	writeKeyPair(t, certFile, keyFile, certPem, keyPem)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, state, err = tlsGet(secureUrl, tls12())
+		if err == nil &&
+			state.PeerCertificates[0].Subject.CommonName == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new certificate not served: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestHttpsRedirectInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ca := newTestCa(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "secure.pem")
+	keyFile := filepath.Join(dir, "secure-key.pem")
+	certPem, keyPem := ca.issue(t, "secure")
+	writeKeyPair(t, certFile, keyFile, certPem, keyPem)
+	port := freePort(t)
+	conf := filepath.Join(dir, "redirect.conf")
+	err = os.WriteFile(conf, []byte(fmt.Sprintf(`
+[servers.secure]
+  bind_ip = "localhost"
+  bind_port = %d
+  type = "https"
+
+  [servers.secure.configurations.http.tls]
+    cert = %q
+    key = %q
+    redirect_port = %d
+`, port, certFile, keyFile, ln.Addr().(*net.TCPAddr).Port)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbc, err := Initialize(true, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutDown(sbc)
+	err = Run(sbc)
+	errs, ok := err.(ServersError)
+	if !ok || errs["secure"] == nil {
+		t.Fatalf("expected the redirect to fail the start, got %v", err)
+	}
+	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err == nil {
+		t.Error("https served without its redirect")
+	}
+}
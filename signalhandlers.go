@@ -1,7 +1,6 @@
 package serverbox
 
 import (
-	. "github.com/ramdrjn/serverbox/internal"
 	"os"
 	"os/signal"
 	"syscall"
@@ -9,18 +8,54 @@ import (
 
 func SetupSignalHandlers(sbcontext *SbContext) {
 	sbcontext.SignalChannel = make(chan os.Signal, 1)
-	signal.Notify(sbcontext.SignalChannel, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sbcontext.SignalChannel, syscall.SIGINT, syscall.SIGTERM,
+		syscall.SIGHUP)
 }
 
+// BlockAndHandleSignal returns once the servers are aborted or shut down,
+// a SIGHUP reloads the configuration and keeps waiting.
 func BlockAndHandleSignal(sbcontext *SbContext) {
-	sig := <-sbcontext.SignalChannel
-	sbcontext.Log.Error("caught signal: ", sig)
-	switch sig {
-	case syscall.SIGINT:
-		//handle SIGINT
-		Abort(sbcontext)
-	case syscall.SIGTERM:
-		//handle SIGTERM
+	for {
+		sig := <-sbcontext.SignalChannel
+		sbcontext.Log.Error("caught signal: ", sig)
+		switch sig {
+		case syscall.SIGHUP:
+			//handle SIGHUP
+			err := Reload(sbcontext)
+			if err != nil {
+				sbcontext.Log.Error("reload failed: ", err)
+			}
+		case syscall.SIGINT:
+			//handle SIGINT
+			Abort(sbcontext)
+			return
+		case syscall.SIGTERM:
+			//handle SIGTERM
+			drain(sbcontext)
+			return
+		}
+	}
+}
+
+// drain shuts the servers down gracefully, a SIGINT or SIGTERM caught
+// while they drain aborts them instead.
+func drain(sbcontext *SbContext) {
+	done := make(chan struct{})
+	go func() {
 		ShutDown(sbcontext)
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-sbcontext.SignalChannel:
+			sbcontext.Log.Error("caught signal while draining: ", sig)
+			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+				Abort(sbcontext)
+				<-done
+				return
+			}
+		}
 	}
 }